@@ -1,5 +1,7 @@
 package bucket
 
+import "errors"
+
 type Buf []byte
 type Block uint64
 
@@ -37,6 +39,9 @@ type Bucket interface {
 	 * linking enables LL/SC like synchronization:
 	 *    subsequent Replace fails if block might have been modified or Discarded since the matching linked Fetch.
 	 * Fetching NOBLOCK returns a buffer with no associated block.
+	 * the buffer is not promised to be a copy: a copyless keeper hands out the block itself, which Replaces
+	 *    change under the reader, possibly half way through reading it. a reader wanting a consistent view
+	 *    fetches with a link and verifies it (see above) once done reading, fetching again if it expired.
 	 */
 	Fetch(d Block, withlink bool) (*Buf, Link, error)
 
@@ -55,19 +60,38 @@ type Bucket interface {
 	Replace(d Block, b *Buf, off uint, l Link, decref bool) error
 
 	/*
-	 * decrefs buffers and frees the underlying blocks when refcount drops to 0
-	 * (also as a result of a subsequent Release)
+	 * marks blocks as discarded: subsequent Fetches fail and outstanding links expire.
+	 * the references held on buffers of the blocks are left alone; a block is freed
+	 * when its refcount drops to 0, i.e. at once if unreferenced, otherwise on the last Release.
 	 */
 	Discard(d ...Block) error
 
-	/* decrefs the buffers but keeps the stored blocks (unless previously marked as discarded) */
+	/*
+	 * decrefs the buffers but keeps the stored blocks (unless previously marked as discarded).
+	 * all buffers are released even if some fail; the first error is returned.
+	 */
 	Release(b ...*Buf) error
 }
 
+/*
+ * a Bucket that can tell which Keep the contents of a block are from.
+ * Gen returns the gen the block was last Kept with; ErrNoBlock if it is not in use, ErrNoGen if that cannot be told.
+ */
+type Genner interface {
+	Gen(d Block) (Gen, error)
+}
+
 type Buckette struct {
 	Bufsize int
 }
 
+var (
+	ErrNoBlock = errors.New("no such block")
+	ErrBufsize = errors.New("buffer exceeds block size")
+	ErrNoRef   = errors.New("buffer not referenced")
+	ErrNoGen   = errors.New("gen not known")
+)
+
 func (l Link) Error() string {
 	return "block rewritten under our feet" // change to something more meaningful describing l
 }
@@ -0,0 +1,411 @@
+package buckettest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+	"bucket"
+)
+
+/*
+ * conformance tests for bucket.Bucket implementations.
+ * call Run from a test of the implementation; newbucket is invoked once per subtest and must return an empty bucket.
+ * the tests only hold an implementation to what the Bucket interface promises:
+ * where the interface leaves room (e.g. whether disjoint linked replaces are coalesced), either choice passes.
+ */
+func Run(t *testing.T, newbucket func(t *testing.T) bucket.Bucket) {
+	for _, tc := range []struct {
+		name string
+		f    func(*testing.T, bucket.Bucket)
+	}{
+		{"KeepFetch", keepfetch},
+		{"DistinctBlocks", distinct},
+		{"GenNeverRecurs", genunique},
+		{"GenOfBlock", genof},
+		{"AnonymousKeep", anonymous},
+		{"Replace", replace},
+		{"LinkedReplace", linked},
+		{"VerifyLink", verify},
+		{"DiscardExpiresLink", discardlink},
+		{"DiscardOnLastRelease", lastrelease},
+		{"Bufsize", bufsize},
+		{"ReleaseUnreferenced", unreferenced},
+		{"ReleaseMany", releasemany},
+		{"ConcurrentLinkedReplace", concurrent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.f(t, newbucket(t))
+		})
+	}
+}
+
+func fill(bkt bucket.Bucket, t *testing.T, s string) *bucket.Buf {
+	b, l, err := bkt.Fetch(bucket.NOBLOCK, false)
+	if err != nil {
+		t.Fatalf("Fetch(NOBLOCK): %v", err)
+	}
+	if l != bucket.NOLINK {
+		t.Errorf("Fetch(NOBLOCK) returned link %v", uint64(l))
+	}
+	copy(*b, s)
+	return b
+}
+
+func keep(bkt bucket.Bucket, t *testing.T, s string) (bucket.Block, bucket.Gen) {
+	bn, gen, err := bkt.Keep(fill(bkt, t, s), true)
+	if err != nil {
+		t.Fatalf("Keep: %v", err)
+	}
+	return bn, gen
+}
+
+func fetch(bkt bucket.Bucket, t *testing.T, bn bucket.Block, withlink bool) (*bucket.Buf, bucket.Link) {
+	b, l, err := bkt.Fetch(bn, withlink)
+	if err != nil {
+		t.Fatalf("Fetch(%v): %v", bn, err)
+	}
+	if withlink && l == bucket.NOLINK {
+		t.Fatalf("Fetch(%v) returned NOLINK", bn)
+	}
+	return b, l
+}
+
+func expect(bkt bucket.Bucket, t *testing.T, bn bucket.Block, s string) {
+	b, _ := fetch(bkt, t, bn, false)
+	if !bytes.HasPrefix(*b, []byte(s)) {
+		t.Errorf("block %v holds %q, want %q", bn, (*b)[:len(s)], s)
+	}
+	bkt.Release(b)
+}
+
+func islink(err error) bool {
+	var l bucket.Link
+	return errors.As(err, &l)
+}
+
+func keepfetch(t *testing.T, bkt bucket.Bucket) {
+	anon := fill(bkt, t, "")
+	bufsize := len(*anon)
+	bkt.Release(anon)
+	if bufsize == 0 {
+		t.Fatal("Fetch(NOBLOCK) returned an empty buffer")
+	}
+
+	bn, _ := keep(bkt, t, "contents")
+	b, _ := fetch(bkt, t, bn, false)
+	if len(*b) != bufsize {
+		t.Errorf("fetched %v bytes, want %v", len(*b), bufsize)
+	}
+	if !bytes.HasPrefix(*b, []byte("contents")) {
+		t.Errorf("fetched %q", (*b)[:8])
+	}
+	if err := bkt.Release(b); err != nil {
+		t.Errorf("Release: %v", err)
+	}
+
+	short := bucket.Buf("short")
+	if bn, _, err := bkt.Keep(&short, false); err != nil {
+		t.Errorf("Keep of a short private buffer: %v", err)
+	} else {
+		expect(bkt, t, bn, "short")
+	}
+}
+
+func distinct(t *testing.T, bkt bucket.Bucket) {
+	seen := make(map[bucket.Block]string)
+	for i := 0; i < 16; i++ {
+		s := string(rune('a' + i))
+		bn, _ := keep(bkt, t, s)
+		if bn == bucket.NOBLOCK {
+			t.Fatal("Keep returned NOBLOCK")
+		}
+		if _, dup := seen[bn]; dup {
+			t.Fatalf("block %v allocated twice", bn)
+		}
+		seen[bn] = s
+	}
+	for bn, s := range seen {
+		expect(bkt, t, bn, s)
+	}
+}
+
+func genunique(t *testing.T, bkt bucket.Bucket) {
+	gens := make(map[bucket.Block]map[bucket.Gen]bool)
+	for i := 0; i < 32; i++ {
+		bn, gen := keep(bkt, t, "x")
+		if gens[bn] == nil {
+			gens[bn] = make(map[bucket.Gen]bool)
+		}
+		if gens[bn][gen] {
+			t.Fatalf("gen %v re-occurred for block %v", gen, bn)
+		}
+		gens[bn][gen] = true
+		if i%2 == 0 {
+			if err := bkt.Discard(bn); err != nil {
+				t.Fatalf("Discard: %v", err)
+			}
+		}
+	}
+}
+
+/*
+ * a Genner tells the gen of the last Keep of a block, and no gen for a discarded one
+ */
+func genof(t *testing.T, bkt bucket.Bucket) {
+	g, ok := bkt.(bucket.Genner)
+	if !ok {
+		t.Skip("not a Genner")
+	}
+	for i := 0; i < 8; i++ {
+		bn, gen := keep(bkt, t, "x")
+		switch got, err := g.Gen(bn); {
+		case errors.Is(err, bucket.ErrNoGen):
+			t.Skip(err)
+		case err != nil || got != gen:
+			t.Fatalf("Gen(%v) = %v, %v; Keep gave %v", bn, got, err, gen)
+		}
+		if err := bkt.Discard(bn); err != nil {
+			t.Fatalf("Discard: %v", err)
+		}
+		if _, err := g.Gen(bn); !errors.Is(err, bucket.ErrNoBlock) {
+			t.Errorf("Gen of a discarded block = %v, want %v", err, bucket.ErrNoBlock)
+		}
+	}
+}
+
+func anonymous(t *testing.T, bkt bucket.Bucket) {
+	b := fill(bkt, t, "anon")
+	bn, _, err := bkt.Keep(b, false)
+	if err != nil {
+		t.Fatalf("Keep: %v", err)
+	}
+	// the buffer retains its reference after being assigned a block
+	if err := bkt.Release(b); err != nil {
+		t.Errorf("Release of a kept anonymous buffer: %v", err)
+	}
+	expect(bkt, t, bn, "anon")
+
+	// a buffer also keeps its reference after being written to a new block
+	f, _ := fetch(bkt, t, bn, false)
+	bn2, _, err := bkt.Keep(f, false)
+	if err != nil {
+		t.Fatalf("Keep of a fetched buffer: %v", err)
+	}
+	if bn2 == bn {
+		t.Errorf("Keep of a fetched buffer returned its block")
+	}
+	if err := bkt.Release(f); err != nil {
+		t.Errorf("Release after Keep: %v", err)
+	}
+	expect(bkt, t, bn2, "anon")
+}
+
+func replace(t *testing.T, bkt bucket.Bucket) {
+	bn, _ := keep(bkt, t, "0123456789")
+	w := bucket.Buf("ab")
+	if err := bkt.Replace(bn, &w, 3, bucket.NOLINK, false); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	expect(bkt, t, bn, "012ab56789")
+
+	b := fill(bkt, t, "whole")
+	if err := bkt.Replace(bn, b, 0, bucket.NOLINK, true); err != nil {
+		t.Fatalf("Replace of a whole block: %v", err)
+	}
+	expect(bkt, t, bn, "whole")
+}
+
+func linked(t *testing.T, bkt bucket.Bucket) {
+	bn, _ := keep(bkt, t, "0123456789")
+	b, l := fetch(bkt, t, bn, true)
+	defer bkt.Release(b)
+
+	w := bucket.Buf("ab")
+	if err := bkt.Replace(bn, &w, 0, l, false); err != nil {
+		t.Fatalf("linked Replace of an unmodified block: %v", err)
+	}
+	if err := bkt.Replace(bn, &w, 1, l, false); !islink(err) {
+		t.Errorf("linked Replace overlapping a modification returned %v, want a Link", err)
+	}
+	expect(bkt, t, bn, "ab23456789")
+
+	// an unlinked replace expires the links handed out before it
+	_, l = fetch(bkt, t, bn, true)
+	if err := bkt.Replace(bn, &w, 0, bucket.NOLINK, false); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if err := bkt.Replace(bn, &w, 0, l, false); !islink(err) {
+		t.Errorf("linked Replace after an unlinked one returned %v, want a Link", err)
+	}
+}
+
+func verify(t *testing.T, bkt bucket.Bucket) {
+	bn, _ := keep(bkt, t, "0123456789")
+	b, l := fetch(bkt, t, bn, true)
+	defer bkt.Release(b)
+
+	empty := bucket.Buf{}
+	if err := bkt.Replace(bn, &empty, 0, l, false); err != nil {
+		t.Fatalf("zero length Replace of an unmodified block: %v", err)
+	}
+	w := bucket.Buf("ab")
+	if err := bkt.Replace(bn, &w, 0, l, false); err != nil {
+		t.Errorf("zero length Replace failed a subsequent linked Replace: %v", err)
+	}
+	if err := bkt.Replace(bn, &empty, 0, l, false); !islink(err) {
+		t.Errorf("zero length Replace of a modified block returned %v, want a Link", err)
+	}
+	expect(bkt, t, bn, "ab23456789")
+}
+
+/*
+ * also while buffers of the block are still referenced:
+ * the link fails, the block cannot be fetched, and every buffer held can still be released
+ */
+func discardlink(t *testing.T, bkt bucket.Bucket) {
+	for _, nbufs := range []int{1, 2} {
+		bn, _ := keep(bkt, t, "x")
+		bufs := []*bucket.Buf{}
+		b, l := fetch(bkt, t, bn, true)
+		for bufs = append(bufs, b); len(bufs) < nbufs; {
+			b, _ = fetch(bkt, t, bn, false)
+			bufs = append(bufs, b)
+		}
+		if err := bkt.Discard(bn); err != nil {
+			t.Fatalf("Discard: %v", err)
+		}
+		empty := bucket.Buf{}
+		if err := bkt.Replace(bn, &empty, 0, l, false); err == nil {
+			t.Errorf("link survived Discard with %v buffers", nbufs)
+		}
+		w := bucket.Buf("y")
+		if err := bkt.Replace(bn, &w, 0, l, false); err == nil {
+			t.Errorf("linked Replace of a discarded block with %v buffers succeeded", nbufs)
+		}
+		if b, _, err := bkt.Fetch(bn, false); err != bucket.ErrNoBlock {
+			t.Errorf("Fetch of a discarded block with %v buffers returned %v, want ErrNoBlock", nbufs, err)
+			if err == nil {
+				bkt.Release(b)
+			}
+		}
+		for i, b := range bufs {
+			if err := bkt.Release(b); err != nil {
+				t.Errorf("Release of buffer %v of %v after Discard: %v", i, nbufs, err)
+			}
+		}
+	}
+}
+
+func lastrelease(t *testing.T, bkt bucket.Bucket) {
+	bn, _ := keep(bkt, t, "x")
+	b1, _ := fetch(bkt, t, bn, false)
+	b2, _ := fetch(bkt, t, bn, false)
+	// references are left alone; the block goes away with the last one
+	if err := bkt.Discard(bn); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if !bytes.HasPrefix(*b1, []byte("x")) || !bytes.HasPrefix(*b2, []byte("x")) {
+		t.Errorf("referenced buffers lost their contents")
+	}
+	if err := bkt.Release(b1); err != nil {
+		t.Errorf("Release after Discard: %v", err)
+	}
+	if b, _, err := bkt.Fetch(bn, false); err != bucket.ErrNoBlock {
+		t.Errorf("Fetch of a discarded block still referenced returned %v, want ErrNoBlock", err)
+		if err == nil {
+			bkt.Release(b)
+		}
+	}
+	if err := bkt.Release(b2); err != nil {
+		t.Errorf("Release of the last buffer after Discard: %v", err)
+	}
+	if _, _, err := bkt.Fetch(bn, false); err != bucket.ErrNoBlock {
+		// the address may have been reused by now, but not while nothing was kept
+		t.Errorf("Fetch of a discarded block returned %v, want ErrNoBlock", err)
+	}
+	if err := bkt.Discard(bn); err == nil {
+		t.Errorf("second Discard of a block succeeded")
+	}
+}
+
+/*
+ * a Release of several buffers releases them all, even past one that fails
+ */
+func releasemany(t *testing.T, bkt bucket.Bucket) {
+	b1, b2 := fill(bkt, t, ""), fill(bkt, t, "")
+	if err := bkt.Release(b1); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := bkt.Release(b1, b2); err == nil {
+		t.Errorf("Release of an unreferenced buffer along with a referenced one succeeded")
+	}
+	if err := bkt.Release(b2); err == nil {
+		t.Errorf("buffer still referenced after a Release that failed on an earlier one")
+	}
+}
+
+func bufsize(t *testing.T, bkt bucket.Bucket) {
+	anon := fill(bkt, t, "")
+	big := make(bucket.Buf, len(*anon)+1)
+	bkt.Release(anon)
+
+	if _, _, err := bkt.Keep(&big, false); err == nil {
+		t.Errorf("Keep of an oversized buffer succeeded")
+	}
+	bn, _ := keep(bkt, t, "x")
+	w := bucket.Buf("ab")
+	if err := bkt.Replace(bn, &w, uint(len(big)-2), bucket.NOLINK, false); err == nil {
+		t.Errorf("Replace past the end of the block succeeded")
+	}
+}
+
+func unreferenced(t *testing.T, bkt bucket.Bucket) {
+	b := fill(bkt, t, "")
+	if err := bkt.Release(b); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := bkt.Release(b); err == nil {
+		t.Errorf("Release of an unreferenced buffer succeeded")
+	}
+}
+
+/*
+ * LL/SC increments from concurrent goroutines must not get lost
+ */
+func concurrent(t *testing.T, bkt bucket.Bucket) {
+	const workers, rounds = 8, 50
+	bn, _ := keep(bkt, t, "")
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; {
+				b, l, err := bkt.Fetch(bn, true)
+				if err != nil {
+					t.Errorf("Fetch: %v", err)
+					return
+				}
+				n := bucket.Buf(binary.LittleEndian.AppendUint64(nil, binary.LittleEndian.Uint64(*b)+1))
+				bkt.Release(b)
+				switch err := bkt.Replace(bn, &n, 0, l, false); {
+				case err == nil:
+					i++
+				case !islink(err):
+					t.Errorf("Replace: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, _ := fetch(bkt, t, bn, false)
+	defer bkt.Release(b)
+	if n := binary.LittleEndian.Uint64(*b); n != workers*rounds {
+		t.Errorf("counter is %v, want %v", n, workers*rounds)
+	}
+}
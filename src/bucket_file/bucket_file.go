@@ -0,0 +1,337 @@
+package bucket_file
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	. "bucket"
+)
+
+/*
+ * file backed, persistent.
+ * the file starts with a header, followed by slots of a fixed size; each slot holds one block.
+ * a slot carries the gen of its last allocation and, while free, the next slot on the free list,
+ * so that Gen never re-occurs for the same Block, also across reopen.
+ * refcounts and links are kept in memory and are private to the process that opened the file.
+ *
+ * header:   magic[8] bufsize[8] nslots[8] freehead[8]
+ * slot:     gen[8] next[8] data[bufsize]
+ */
+type Bucket_file struct {
+	Buckette
+	mu       sync.Mutex
+	f        *os.File
+	nslots   uint64
+	freehead Block
+	clock    uint64 // bumped on every modification; links are clock readings
+	blocks   map[Block]*fileblock
+	bufs     map[*Buf]*filebuf
+}
+
+const (
+	magic    = "bktfile1"
+	hdrsize  = 32
+	metasize = 16
+	inuse    = NOBLOCK - 1 // next of an allocated slot
+)
+
+var ErrFormat = errors.New("not a bucket file, or bufsize mismatch")
+
+type fileblock struct {
+	modified  uint64 // clock at last modification
+	refs      int
+	discarded bool
+}
+
+type filebuf struct {
+	bn   Block // NOBLOCK for anonymous buffers
+	refs int
+}
+
+/*
+ * open the bucket stored at path, creating it if it does not exist.
+ * bufsize must match the one the file was created with.
+ */
+func Open(path string, bufsize int) (*Bucket_file, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	k := &Bucket_file{
+		Buckette: Buckette{Bufsize: bufsize},
+		f:        f,
+		freehead: NOBLOCK,
+		blocks:   make(map[Block]*fileblock),
+		bufs:     make(map[*Buf]*filebuf),
+	}
+	var hdr [hdrsize]byte
+	var fi os.FileInfo
+
+	if fi, err = f.Stat(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		err = k.writeheader()
+	} else if _, err = f.ReadAt(hdr[:], 0); err == nil {
+		if string(hdr[:8]) != magic || binary.LittleEndian.Uint64(hdr[8:]) != uint64(bufsize) {
+			err = ErrFormat
+		}
+		k.nslots = binary.LittleEndian.Uint64(hdr[16:])
+		k.freehead = Block(binary.LittleEndian.Uint64(hdr[24:]))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *Bucket_file) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.f.Close()
+}
+
+func (k *Bucket_file) Sync() error {
+	return k.f.Sync()
+}
+
+func (k *Bucket_file) writeheader() error {
+	var hdr [hdrsize]byte
+
+	copy(hdr[:], magic)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(k.Bufsize))
+	binary.LittleEndian.PutUint64(hdr[16:], k.nslots)
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(k.freehead))
+	_, err := k.f.WriteAt(hdr[:], 0)
+	return err
+}
+
+func (k *Bucket_file) slotoff(bn Block) int64 {
+	return hdrsize + int64(bn)*int64(metasize+k.Bufsize)
+}
+
+func (k *Bucket_file) readmeta(bn Block) (gen Gen, next Block, err error) {
+	var meta [metasize]byte
+
+	if uint64(bn) >= k.nslots {
+		return 0, NOBLOCK, ErrNoBlock
+	}
+	if _, err = k.f.ReadAt(meta[:], k.slotoff(bn)); err != nil {
+		return
+	}
+	return Gen(binary.LittleEndian.Uint64(meta[:])), Block(binary.LittleEndian.Uint64(meta[8:])), nil
+}
+
+func (k *Bucket_file) writemeta(bn Block, gen Gen, next Block) error {
+	var meta [metasize]byte
+
+	binary.LittleEndian.PutUint64(meta[:], uint64(gen))
+	binary.LittleEndian.PutUint64(meta[8:], uint64(next))
+	_, err := k.f.WriteAt(meta[:], k.slotoff(bn))
+	return err
+}
+
+func (k *Bucket_file) block(bn Block) *fileblock {
+	blk := k.blocks[bn]
+	if blk == nil {
+		blk = &fileblock{}
+		k.blocks[bn] = blk
+	}
+	return blk
+}
+
+/*
+ * an allocated slot that is not pending discard
+ */
+func (k *Bucket_file) live(bn Block) bool {
+	if blk := k.blocks[bn]; blk != nil && blk.discarded {
+		return false
+	}
+	_, next, err := k.readmeta(bn)
+	return err == nil && next == inuse
+}
+
+func (k *Bucket_file) Keep(b *Buf, decref bool) (Block, Gen, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(*b) > k.Bufsize {
+		return NOBLOCK, 0, ErrBufsize
+	}
+	bn, nslots, freehead := k.freehead, k.nslots, k.freehead
+	gen, next := Gen(0), NOBLOCK
+	if bn != NOBLOCK {
+		var err error
+		if gen, next, err = k.readmeta(bn); err != nil {
+			return NOBLOCK, 0, err
+		}
+	} else {
+		bn = Block(nslots)
+	}
+	data := make([]byte, k.Bufsize)
+	copy(data, *b)
+	if _, err := k.f.WriteAt(data, k.slotoff(bn)+metasize); err != nil {
+		return NOBLOCK, 0, err
+	}
+	// unlink the slot before claiming it, so that its next pointer is kept until the header no longer needs it.
+	// failing (or crashing) in between leaks the slot, but never corrupts the free list.
+	if k.freehead = next; bn == Block(nslots) {
+		k.nslots++
+	}
+	if err := k.writeheader(); err != nil {
+		k.nslots, k.freehead = nslots, freehead
+		return NOBLOCK, 0, err
+	}
+	gen++
+	if err := k.writemeta(bn, gen, inuse); err != nil {
+		k.nslots, k.freehead = nslots, freehead
+		k.writeheader() // best effort to get the slot back on the free list
+		return NOBLOCK, 0, err
+	}
+
+	k.clock++
+	blk := &fileblock{modified: k.clock}
+	k.blocks[bn] = blk
+	if fb := k.bufs[b]; fb != nil && fb.bn == NOBLOCK {
+		fb.bn = bn
+		blk.refs = fb.refs
+	}
+	if decref {
+		return bn, gen, k.release(b)
+	}
+	return bn, gen, nil
+}
+
+func (k *Bucket_file) Fetch(d Block, withlink bool) (*Buf, Link, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	b := Buf(make([]byte, k.Bufsize))
+	if d == NOBLOCK {
+		k.bufs[&b] = &filebuf{bn: NOBLOCK, refs: 1}
+		return &b, NOLINK, nil
+	}
+	if !k.live(d) {
+		return nil, NOLINK, ErrNoBlock
+	}
+	if _, err := k.f.ReadAt(b, k.slotoff(d)+metasize); err != nil {
+		return nil, NOLINK, err
+	}
+	k.bufs[&b] = &filebuf{bn: d, refs: 1}
+	k.block(d).refs++
+
+	l := NOLINK
+	if withlink {
+		l = Link(k.clock + 1) // NOLINK is zero
+	}
+	return &b, l, nil
+}
+
+func (k *Bucket_file) Gen(d Block) (Gen, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !k.live(d) {
+		return 0, ErrNoBlock
+	}
+	gen, _, err := k.readmeta(d)
+	return gen, err
+}
+
+func (k *Bucket_file) Replace(d Block, b *Buf, off uint, l Link, decref bool) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	switch {
+	case !k.live(d) && l == NOLINK:
+		return ErrNoBlock
+	case off+uint(len(*b)) > uint(k.Bufsize):
+		return ErrBufsize
+	case l != NOLINK && (!k.live(d) || k.block(d).modified >= uint64(l)):
+		return l
+	}
+	if len(*b) > 0 {
+		if _, err := k.f.WriteAt(*b, k.slotoff(d)+metasize+int64(off)); err != nil {
+			return err
+		}
+		k.clock++
+		k.block(d).modified = k.clock
+	}
+	if decref {
+		return k.release(b)
+	}
+	return nil
+}
+
+func (k *Bucket_file) Discard(d ...Block) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, bn := range d {
+		if !k.live(bn) {
+			return ErrNoBlock
+		}
+		k.clock++
+		blk := k.block(bn)
+		blk.discarded = true
+		blk.modified = k.clock
+		if blk.refs == 0 {
+			if err := k.free(bn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (k *Bucket_file) Release(b ...*Buf) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var err error
+	for _, buf := range b {
+		if e := k.release(buf); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (k *Bucket_file) release(b *Buf) error {
+	fb := k.bufs[b]
+	if fb == nil {
+		return ErrNoRef
+	}
+	if fb.refs--; fb.refs == 0 {
+		delete(k.bufs, b)
+	}
+	if fb.bn == NOBLOCK {
+		return nil
+	}
+	if blk := k.blocks[fb.bn]; blk != nil {
+		if blk.refs--; blk.refs == 0 && blk.discarded {
+			return k.free(fb.bn)
+		}
+	}
+	return nil
+}
+
+/*
+ * push a slot on the persistent free list; its gen is retained for the next allocation
+ */
+func (k *Bucket_file) free(bn Block) error {
+	gen, _, err := k.readmeta(bn)
+	if err != nil {
+		return err
+	}
+	if err = k.writemeta(bn, gen, k.freehead); err != nil {
+		return err
+	}
+	delete(k.blocks, bn)
+	k.freehead = bn
+	return k.writeheader()
+}
@@ -0,0 +1,92 @@
+package bucket_file
+
+import (
+	"path/filepath"
+	"testing"
+	"bucket"
+	"bucket/buckettest"
+)
+
+func TestBucket(t *testing.T) {
+	buckettest.Run(t, func(t *testing.T) bucket.Bucket {
+		k, err := Open(filepath.Join(t.TempDir(), "bucket"), 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { k.Close() })
+		return k
+	})
+}
+
+/*
+ * contents, the free list and gens survive Close and Open
+ */
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucket")
+	k, err := Open(path, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep := func(s string) (bucket.Block, bucket.Gen) {
+		b := bucket.Buf(s)
+		bn, gen, err := k.Keep(&b, false)
+		if err != nil {
+			t.Fatalf("Keep: %v", err)
+		}
+		return bn, gen
+	}
+	reopen := func() {
+		if err := k.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if k, err = Open(path, 64); err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+	}
+
+	gens := make(map[bucket.Block]bucket.Gen)
+	var bns []bucket.Block
+	for _, s := range []string{"a", "b", "c", "d"} {
+		bn, gen := keep(s)
+		bns = append(bns, bn)
+		gens[bn] = gen
+	}
+	if err := k.Discard(bns[1], bns[2]); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	reopen()
+
+	for i, s := range []string{"a", "", "", "d"} {
+		b, _, err := k.Fetch(bns[i], false)
+		switch {
+		case s == "" && err != bucket.ErrNoBlock:
+			t.Errorf("Fetch of discarded block %v after reopen: %v", bns[i], err)
+		case s != "" && err != nil:
+			t.Errorf("Fetch of block %v after reopen: %v", bns[i], err)
+		case s != "" && (*b)[0] != s[0]:
+			t.Errorf("block %v holds %q after reopen, want %q", bns[i], (*b)[:1], s)
+		}
+		if err == nil {
+			k.Release(b)
+		}
+	}
+
+	// the discarded slots are reused before the file grows, with gens not seen before
+	for i := 0; i < 2; i++ {
+		bn, gen := keep("e")
+		if bn != bns[1] && bn != bns[2] {
+			t.Errorf("Keep after reopen allocated %v, want one of the discarded %v and %v", bn, bns[1], bns[2])
+		}
+		if gen <= gens[bn] {
+			t.Errorf("gen %v for block %v after reopen, had %v before", gen, bn, gens[bn])
+		}
+	}
+	if bn, _ := keep("f"); bn != bns[3]+1 {
+		t.Errorf("Keep with an empty free list allocated %v, want %v", bn, bns[3]+1)
+	}
+	k.Close()
+
+	if _, err := Open(path, 128); err != ErrFormat {
+		t.Errorf("Open with another bufsize: %v, want ErrFormat", err)
+	}
+}
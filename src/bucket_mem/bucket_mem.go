@@ -1,36 +1,231 @@
 package bucket_mem
 
-import . "bucket"
-
-type Bucket_mem Buckette
+import (
+	"sync"
+	. "bucket"
+)
 
 /*
- * a full implementation will keep refcounts on buffers; this one merely creates copies.
- * also, in a full implementation we may want to force a context switch and a rendezvous just before issuing a write, to enable more coalescing.
+ * memory only, primitive -- for debugging.
+ * every Fetch creates a private copy of the block; refcounts are kept per copy,
+ * and a block counts the references held by all the copies made of it.
+ * writes are not coalesced: each Replace is applied as soon as it is issued,
+ * and links are validated against the ranges modified since the linked Fetch,
+ * so that linked replaces of disjoint ranges succeed as if they had been coalesced.
  */
+type Bucket_mem struct {
+	Buckette
+	mu     sync.Mutex
+	blocks map[Block]*memblock
+	free   []Block
+	next   Block  // lowest never allocated address
+	gen    Gen    // last gen handed out
+	clock  uint64 // bumped on every modification; links are clock readings
+	bufs   map[*Buf]*membuf
+}
+
+const maxmods = 32 // modifications remembered per block; links older than those fail
+
+type memblock struct {
+	data      []byte
+	gen       Gen
+	horizon   uint64 // links older than this are expired
+	mods      []mod  // modifications after horizon, oldest first
+	refs      int    // sum of references of buffers fetched from (or kept to) this block
+	discarded bool
+}
+
+type mod struct {
+	clock    uint64
+	off, end uint
+}
+
+type membuf struct {
+	bn   Block // NOBLOCK for anonymous buffers
+	refs int
+}
+
+func New(bufsize int) *Bucket_mem {
+	return &Bucket_mem{Buckette: Buckette{Bufsize: bufsize}}
+}
+
+func (k *Bucket_mem) init() {
+	if k.blocks == nil {
+		k.blocks = make(map[Block]*memblock)
+		k.bufs = make(map[*Buf]*membuf)
+	}
+}
+
+func (k *Bucket_mem) Keep(b *Buf, decref bool) (Block, Gen, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.init()
 
-func (k Bucket_mem) Keep(b *Buf, decref bool) (Block, Gen, error) {
-	return Block(0), Gen(0), nil
+	if len(*b) > k.Bufsize {
+		return NOBLOCK, 0, ErrBufsize
+	}
+	bn := k.next
+	if n := len(k.free); n > 0 {
+		bn = k.free[n-1]
+		k.free = k.free[:n-1]
+	} else {
+		k.next++
+	}
+	k.gen++
+	k.clock++
+	blk := &memblock{data: make([]byte, k.Bufsize), gen: k.gen, horizon: k.clock}
+	copy(blk.data, *b)
+	k.blocks[bn] = blk
+
+	if mb := k.bufs[b]; mb != nil && mb.bn == NOBLOCK {
+		mb.bn = bn
+		blk.refs = mb.refs
+	}
+	if decref {
+		if err := k.release(b); err != nil {
+			return bn, blk.gen, err
+		}
+	}
+	return bn, blk.gen, nil
 }
 
-func (k Bucket_mem) Fetch(d Block, withlink bool) (*Buf, Link, error) {
+func (k *Bucket_mem) Fetch(d Block, withlink bool) (*Buf, Link, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.init()
+
+	if d == NOBLOCK {
+		b := Buf(make([]byte, k.Bufsize))
+		k.bufs[&b] = &membuf{bn: NOBLOCK, refs: 1}
+		return &b, NOLINK, nil
+	}
+	blk := k.blocks[d]
+	if blk == nil || blk.discarded {
+		return nil, NOLINK, ErrNoBlock
+	}
 	b := Buf(make([]byte, k.Bufsize))
-	l := NOLINK
+	copy(b, blk.data)
+	k.bufs[&b] = &membuf{bn: d, refs: 1}
+	blk.refs++
 
+	l := NOLINK
+	if withlink {
+		l = Link(k.clock)
+	}
 	return &b, l, nil
 }
 
-func (k Bucket_mem) Replace(d Block, b *Buf, off uint, l Link, decref bool) error {
-	if l != NOLINK {
+func (k *Bucket_mem) Gen(d Block) (Gen, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.init()
+
+	blk := k.blocks[d]
+	if blk == nil || blk.discarded {
+		return 0, ErrNoBlock
+	}
+	return blk.gen, nil
+}
+
+/*
+ * a link is valid if the block was neither discarded nor reallocated since it was handed out,
+ * and no modification since then overlaps [off, end).
+ * an empty range overlaps any modification.
+ */
+func (blk *memblock) valid(l Link, off, end uint) bool {
+	if blk.discarded || uint64(l) < blk.horizon {
+		return false
+	}
+	for i := len(blk.mods) - 1; i >= 0 && blk.mods[i].clock > uint64(l); i-- {
+		if m := blk.mods[i]; off == end || (off < m.end && m.off < end) {
+			return false
+		}
+	}
+	return true
+}
+
+func (k *Bucket_mem) Replace(d Block, b *Buf, off uint, l Link, decref bool) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.init()
+
+	blk := k.blocks[d]
+	switch {
+	case blk == nil || (blk.discarded && l == NOLINK):
+		return ErrNoBlock
+	case off+uint(len(*b)) > uint(k.Bufsize):
+		return ErrBufsize
+	case l != NOLINK && !blk.valid(l, off, off+uint(len(*b))):
 		return l // this is how to fail a store-linked; other errors are handled according to their types.
 	}
+	if len(*b) > 0 {
+		k.clock++
+		copy(blk.data[off:], *b)
+		blk.mods = append(blk.mods, mod{clock: k.clock, off: off, end: off + uint(len(*b))})
+		if len(blk.mods) > maxmods {
+			blk.horizon = blk.mods[0].clock
+			blk.mods = blk.mods[1:]
+		}
+	}
+	if decref {
+		return k.release(b)
+	}
 	return nil
 }
 
-func (k Bucket_mem) Discard(d ...Block) error {
+func (k *Bucket_mem) Discard(d ...Block) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.init()
+
+	for _, bn := range d {
+		blk := k.blocks[bn]
+		if blk == nil || blk.discarded {
+			return ErrNoBlock
+		}
+		k.clock++
+		blk.discarded = true
+		if blk.refs == 0 {
+			k.drop(bn)
+		}
+	}
 	return nil
 }
 
-func (k Bucket_mem) Release(b ...*Buf) error {
+func (k *Bucket_mem) Release(b ...*Buf) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.init()
+
+	var err error
+	for _, buf := range b {
+		if e := k.release(buf); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (k *Bucket_mem) release(b *Buf) error {
+	mb := k.bufs[b]
+	if mb == nil {
+		return ErrNoRef
+	}
+	if mb.refs--; mb.refs == 0 {
+		delete(k.bufs, b)
+	}
+	if mb.bn == NOBLOCK {
+		return nil
+	}
+	if blk := k.blocks[mb.bn]; blk != nil {
+		if blk.refs--; blk.refs == 0 && blk.discarded {
+			k.drop(mb.bn)
+		}
+	}
 	return nil
 }
+
+func (k *Bucket_mem) drop(bn Block) {
+	delete(k.blocks, bn)
+	k.free = append(k.free, bn)
+}
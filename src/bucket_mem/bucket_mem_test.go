@@ -0,0 +1,11 @@
+package bucket_mem
+
+import (
+	"testing"
+	"bucket"
+	"bucket/buckettest"
+)
+
+func TestBucket(t *testing.T) {
+	buckettest.Run(t, func(t *testing.T) bucket.Bucket { return New(256) })
+}
@@ -0,0 +1,429 @@
+package bucket_proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	. "bucket"
+)
+
+/*
+ * proxy client/server that enable using any bucket in a separate address space/process/container/host.
+ * the server exports a Bucket over a connection (unix or tcp socket, net.Pipe, ...) using net/rpc;
+ * Bucket_proxy is the client side and is itself a Bucket.
+ * buffers are copied over the wire; each buffer the client holds is backed by a referenced buffer on the server,
+ * which the server releases when the connection goes away.
+ * links, gens and block addresses are carried verbatim, and a failed link is returned as a Link, as with any bucket.
+ */
+
+type Request struct {
+	Block    Block
+	Blocks   []Block
+	Buf      uint64   // server side buffer; 0 for buffers that did not come from Fetch
+	Bufs     []uint64 // for Release
+	Data     []byte
+	Off      uint
+	Link     Link
+	Decref   bool
+	Withlink bool
+}
+
+type Response struct {
+	Block   Block
+	Gen     Gen
+	Link    Link
+	Buf     uint64
+	Data    []byte
+	Bufsize int
+	Err     int // one of the err* codes
+	Msg     string
+}
+
+const (
+	errnone = iota
+	errlink
+	errnoblock
+	errbufsize
+	errnoref
+	errnogen
+	errother
+)
+
+/*
+ * per connection state of the server.
+ * the server holds a single reference to each block the client holds buffers of, and counts the client's
+ * buffers itself; the block is released when the client lets go of its last buffer.
+ * a Discard is passed on at once; it leaves references alone, so that a discarded block is freed by the
+ * served bucket once every session holding buffers of it has released them.
+ */
+type session struct {
+	bkt    Bucket
+	bufs   map[uint64]*sbuf
+	blocks map[Block]*sblock
+	next   uint64
+	mu     sync.Mutex
+}
+
+type sbuf struct {
+	bn   Block // NOBLOCK for anonymous buffers
+	anon *Buf
+}
+
+type sblock struct {
+	b    *Buf
+	refs int
+}
+
+func (r *Response) seterr(err error) {
+	var l Link
+
+	switch {
+	case err == nil:
+	case errors.As(err, &l):
+		r.Err, r.Link = errlink, l
+	case errors.Is(err, ErrNoBlock):
+		r.Err = errnoblock
+	case errors.Is(err, ErrBufsize):
+		r.Err = errbufsize
+	case errors.Is(err, ErrNoRef):
+		r.Err = errnoref
+	case errors.Is(err, ErrNoGen):
+		r.Err = errnogen
+	default:
+		r.Err, r.Msg = errother, err.Error()
+	}
+}
+
+func (r *Response) err() error {
+	switch r.Err {
+	case errnone:
+		return nil
+	case errlink:
+		return r.Link
+	case errnoblock:
+		return ErrNoBlock
+	case errbufsize:
+		return ErrBufsize
+	case errnoref:
+		return ErrNoRef
+	case errnogen:
+		return ErrNoGen
+	default:
+		return errors.New(r.Msg)
+	}
+}
+
+/*
+ * serve b on every connection accepted from l, until l fails.
+ */
+func Serve(l net.Listener, b Bucket, bufsize int) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ServeConn(conn, b, bufsize)
+	}
+}
+
+/*
+ * serve b on a single connection; returns when the connection is closed,
+ * after releasing all buffers still held on behalf of the client.
+ */
+func ServeConn(conn io.ReadWriteCloser, b Bucket, bufsize int) {
+	s := &session{bkt: b, bufs: make(map[uint64]*sbuf), blocks: make(map[Block]*sblock)}
+	srv := rpc.NewServer()
+	srv.RegisterName("Bucket", &proxy{s: s, bufsize: bufsize})
+	srv.ServeConn(conn)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.bufs {
+		s.release(id)
+	}
+}
+
+type proxy struct {
+	s       *session
+	bufsize int
+}
+
+func (s *session) add(e *sbuf) uint64 {
+	s.next++
+	s.bufs[s.next] = e
+	return s.next
+}
+
+func (s *session) release(id uint64) error {
+	e := s.bufs[id]
+	if e == nil {
+		return ErrNoRef
+	}
+	delete(s.bufs, id)
+	if e.bn == NOBLOCK {
+		return s.bkt.Release(e.anon)
+	}
+	blk := s.blocks[e.bn]
+	if blk.refs--; blk.refs > 0 {
+		return nil
+	}
+	delete(s.blocks, e.bn)
+	return s.bkt.Release(blk.b)
+}
+
+func (p *proxy) Info(req *Request, resp *Response) error {
+	resp.Bufsize = p.bufsize
+	return nil
+}
+
+/*
+ * the client's copy is what gets kept. an anonymous server buffer is filled with it and kept in its stead,
+ * so that it is assigned the block and retains its reference; other buffers (which may alias a stored block)
+ * are left alone, merely decrefed if asked to.
+ */
+func (p *proxy) Keep(req *Request, resp *Response) error {
+	var err error
+	s := p.s
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(req.Data) > p.bufsize { // would be truncated into an anonymous buffer
+		resp.seterr(ErrBufsize)
+		return nil
+	}
+	if e := s.bufs[req.Buf]; e != nil && e.bn == NOBLOCK {
+		copy(*e.anon, req.Data)
+		if resp.Block, resp.Gen, err = s.bkt.Keep(e.anon, false); err == nil {
+			s.blocks[resp.Block] = &sblock{b: e.anon, refs: 1}
+			e.bn, e.anon = resp.Block, nil
+		}
+	} else {
+		tmp := Buf(req.Data)
+		resp.Block, resp.Gen, err = s.bkt.Keep(&tmp, false)
+	}
+	if err == nil && req.Decref && req.Buf != 0 {
+		err = s.release(req.Buf)
+	}
+	resp.seterr(err)
+	return nil
+}
+
+func (p *proxy) Fetch(req *Request, resp *Response) error {
+	s := p.s
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, l, err := s.bkt.Fetch(req.Block, req.Withlink)
+	if resp.seterr(err); err != nil {
+		return nil
+	}
+	resp.Data = append([]byte(nil), *b...)
+	resp.Link = l
+	if req.Block == NOBLOCK {
+		resp.Buf = s.add(&sbuf{bn: NOBLOCK, anon: b})
+		return nil
+	}
+	if blk := s.blocks[req.Block]; blk != nil {
+		s.bkt.Release(blk.b) // hold on to the fresher copy only
+		blk.b = b
+		blk.refs++
+	} else {
+		s.blocks[req.Block] = &sblock{b: b, refs: 1}
+	}
+	resp.Buf = s.add(&sbuf{bn: req.Block})
+	return nil
+}
+
+func (p *proxy) Replace(req *Request, resp *Response) error {
+	s := p.s
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := Buf(req.Data)
+	err := s.bkt.Replace(req.Block, &data, req.Off, req.Link, false)
+	if err == nil && req.Decref && req.Buf != 0 {
+		err = s.release(req.Buf)
+	}
+	resp.seterr(err)
+	return nil
+}
+
+/*
+ * ErrNoGen if the served bucket cannot tell.
+ */
+func (p *proxy) Gen(req *Request, resp *Response) error {
+	g, ok := p.s.bkt.(Genner)
+	if !ok {
+		resp.seterr(ErrNoGen)
+		return nil
+	}
+	var err error
+	resp.Gen, err = g.Gen(req.Block)
+	resp.seterr(err)
+	return nil
+}
+
+func (p *proxy) Discard(req *Request, resp *Response) error {
+	s := p.s
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp.seterr(s.bkt.Discard(req.Blocks...))
+	return nil
+}
+
+func (p *proxy) Release(req *Request, resp *Response) error {
+	s := p.s
+	var err error
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range req.Bufs {
+		if e := s.release(id); e != nil && err == nil {
+			err = e
+		}
+	}
+	resp.seterr(err)
+	return nil
+}
+
+type Bucket_proxy struct {
+	Buckette
+	c    *rpc.Client
+	mu   sync.Mutex
+	bufs map[*Buf]bufinfo
+}
+
+type bufinfo struct {
+	id uint64
+	bn Block
+}
+
+func Dial(network, addr string) (*Bucket_proxy, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn)
+}
+
+func NewClient(conn io.ReadWriteCloser) (*Bucket_proxy, error) {
+	k := &Bucket_proxy{c: rpc.NewClient(conn), bufs: make(map[*Buf]bufinfo)}
+	var resp Response
+
+	if err := k.c.Call("Bucket.Info", &Request{}, &resp); err != nil {
+		k.c.Close()
+		return nil, err
+	}
+	k.Bufsize = resp.Bufsize
+	return k, nil
+}
+
+func (k *Bucket_proxy) Close() error {
+	return k.c.Close()
+}
+
+func (k *Bucket_proxy) call(method string, req *Request) (*Response, error) {
+	var resp Response
+
+	if err := k.c.Call("Bucket."+method, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, resp.err()
+}
+
+func (k *Bucket_proxy) lookup(b *Buf, drop bool) bufinfo {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	bi, ok := k.bufs[b]
+	if !ok {
+		return bufinfo{bn: NOBLOCK}
+	}
+	if drop {
+		delete(k.bufs, b)
+	}
+	return bi
+}
+
+/*
+ * the record of the buffer is dropped only once the server has released it, as with Replace.
+ */
+func (k *Bucket_proxy) Keep(b *Buf, decref bool) (Block, Gen, error) {
+	bi := k.lookup(b, false)
+	resp, err := k.call("Keep", &Request{Buf: bi.id, Data: *b, Decref: decref})
+	if err != nil {
+		if resp == nil {
+			return NOBLOCK, 0, err
+		}
+		return resp.Block, resp.Gen, err
+	}
+	if bi.id != 0 {
+		k.mu.Lock()
+		if decref {
+			delete(k.bufs, b)
+		} else if bi.bn == NOBLOCK {
+			k.bufs[b] = bufinfo{id: bi.id, bn: resp.Block}
+		}
+		k.mu.Unlock()
+	}
+	return resp.Block, resp.Gen, nil
+}
+
+func (k *Bucket_proxy) Fetch(d Block, withlink bool) (*Buf, Link, error) {
+	resp, err := k.call("Fetch", &Request{Block: d, Withlink: withlink})
+	if err != nil {
+		return nil, NOLINK, err
+	}
+	b := Buf(resp.Data)
+	k.mu.Lock()
+	k.bufs[&b] = bufinfo{id: resp.Buf, bn: d}
+	k.mu.Unlock()
+	return &b, resp.Link, nil
+}
+
+func (k *Bucket_proxy) Replace(d Block, b *Buf, off uint, l Link, decref bool) error {
+	bi := k.lookup(b, false)
+	_, err := k.call("Replace", &Request{Block: d, Buf: bi.id, Data: *b, Off: off, Link: l, Decref: decref})
+	if err == nil && decref {
+		k.lookup(b, true)
+	}
+	return err
+}
+
+func (k *Bucket_proxy) Gen(d Block) (Gen, error) {
+	resp, err := k.call("Gen", &Request{Block: d})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Gen, nil
+}
+
+/*
+ * buffers the client holds of the blocks stay referenced, and are released as usual.
+ */
+func (k *Bucket_proxy) Discard(d ...Block) error {
+	_, err := k.call("Discard", &Request{Blocks: d})
+	return err
+}
+
+func (k *Bucket_proxy) Release(b ...*Buf) error {
+	req := &Request{Bufs: make([]uint64, 0, len(b))}
+	var err error
+
+	for _, buf := range b {
+		if bi := k.lookup(buf, true); bi.id != 0 {
+			req.Bufs = append(req.Bufs, bi.id)
+		} else if err == nil {
+			err = ErrNoRef
+		}
+	}
+	if len(req.Bufs) > 0 {
+		if _, e := k.call("Release", req); err == nil {
+			err = e
+		}
+	}
+	return err
+}
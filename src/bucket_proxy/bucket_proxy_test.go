@@ -0,0 +1,87 @@
+package bucket_proxy
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"bucket"
+	"bucket/buckettest"
+	"bucket_mem"
+)
+
+/*
+ * a client over net.Pipe to a server of bucket_mem is held to what bucket_mem itself is
+ */
+func TestLoopback(t *testing.T) {
+	buckettest.Run(t, func(t *testing.T) bucket.Bucket {
+		server, client := net.Pipe()
+		go ServeConn(server, bucket_mem.New(256), 256)
+		k, err := NewClient(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { k.Close() })
+		return k
+	})
+}
+
+/*
+ * two sessions of one served bucket: neither one's Discard takes the other's references,
+ * and the block is freed once both have released their buffers
+ */
+func TestSessions(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	mem := bucket_mem.New(64)
+	go Serve(l, mem, 64)
+
+	var k [2]*Bucket_proxy
+	for i := range k {
+		if k[i], err = Dial("unix", l.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+		defer k[i].Close()
+	}
+
+	w := bucket.Buf("x")
+	bn, _, err := k[0].Keep(&w, false)
+	if err != nil {
+		t.Fatalf("Keep: %v", err)
+	}
+	var b [2]*bucket.Buf
+	for i := range k {
+		if b[i], _, err = k[i].Fetch(bn, false); err != nil {
+			t.Fatalf("Fetch from client %v: %v", i, err)
+		}
+	}
+	if err := k[0].Discard(bn); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if _, _, err := k[1].Fetch(bn, false); err != bucket.ErrNoBlock {
+		t.Errorf("Fetch of a block discarded by the other client: %v", err)
+	}
+	for i := range k {
+		if err := k[i].Release(b[i]); err != nil {
+			t.Errorf("Release from client %v after Discard: %v", i, err)
+		}
+	}
+	if _, _, err := mem.Fetch(bn, false); err != bucket.ErrNoBlock {
+		t.Errorf("discarded block still stored after both clients released it: %v", err)
+	}
+
+	// a failed Keep leaves the buffer with the client, to be released as usual
+	a, _, err := k[1].Fetch(bucket.NOBLOCK, false)
+	if err != nil {
+		t.Fatalf("Fetch(NOBLOCK): %v", err)
+	}
+	*a = append(*a, 0)
+	if _, _, err := k[1].Keep(a, true); err != bucket.ErrBufsize {
+		t.Errorf("Keep of an oversized buffer: %v, want ErrBufsize", err)
+	}
+	if err := k[1].Release(a); err != nil {
+		t.Errorf("Release after a failed Keep: %v", err)
+	}
+}
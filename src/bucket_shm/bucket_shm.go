@@ -0,0 +1,351 @@
+//go:build linux
+
+package bucket_shm
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	. "bucket"
+)
+
+/*
+ * shared memory based: open(/dev/shm/xxx) + mmap, with refcounts and copyless buffers.
+ * Fetch returns a Buf aliasing the mapped block, so that readers see replaces as they happen;
+ * an anonymous buffer (Fetch(NOBLOCK)) is a reserved slot, and Keeping it merely assigns it.
+ * a read racing a Replace may see the block half written: nothing is copied to keep readers from it,
+ * they verify their link once done reading instead, as bucket.Fetch has it.
+ *
+ * NOTE: buffers of fetched blocks are READ-ONLY. writing one in place modifies the block
+ *   for every attachment without a Replace, and so without expiring anybody's links.
+ *   modify a block through Replace (or a private copy passed to Keep) only.
+ *   anonymous buffers are private until Kept and may be written freely.
+ *
+ * allocation state, gens, versions (from which links are taken) and refcounts live in the segment,
+ * so that all processes attaching to it share them; a flock on the segment serializes updates.
+ * references held by a process that dies are not recovered.
+ *
+ * header:   magic[8] bufsize[8] nslots[8] freehead[8]
+ * slot:     gen[8] next[8] version[8] refs[8] data[bufsize]
+ */
+type Bucket_shm struct {
+	Buckette
+	mu     sync.Mutex
+	f      *os.File
+	mem    []byte
+	nslots uint64
+	bufs   map[*Buf]Block // buffers handed out by this process
+}
+
+const (
+	magic    = "bktshm01"
+	hdrsize  = 32
+	metasize = 32
+	inuse    = NOBLOCK - 1 // next of an allocated slot
+	anon     = NOBLOCK - 2 // next of a slot reserved by Fetch(NOBLOCK)
+	dropped  = NOBLOCK - 3 // next of a discarded slot that is still referenced
+)
+
+var (
+	ErrFormat = errors.New("not a bucket segment, or bufsize mismatch")
+	ErrFull   = errors.New("bucket segment full")
+)
+
+var le = binary.LittleEndian
+
+/*
+ * attach to /dev/shm/name, creating it with room for nblocks blocks if it does not exist.
+ * bufsize must match the one the segment was created with; nblocks is ignored when attaching.
+ */
+func Open(name string, bufsize, nblocks int) (*Bucket_shm, error) {
+	f, err := os.OpenFile("/dev/shm/"+name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	k := &Bucket_shm{Buckette: Buckette{Bufsize: bufsize}, f: f, bufs: make(map[*Buf]Block)}
+	if err = k.attach(uint64(nblocks)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *Bucket_shm) attach(nblocks uint64) error {
+	if err := syscall.Flock(int(k.f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(k.f.Fd()), syscall.LOCK_UN)
+
+	fi, err := k.f.Stat()
+	if err != nil {
+		return err
+	}
+	fresh := fi.Size() == 0
+	if fresh {
+		if err = k.f.Truncate(hdrsize + int64(nblocks)*int64(metasize+k.Bufsize)); err != nil {
+			return err
+		}
+	} else {
+		var hdr [hdrsize]byte
+		if _, err = k.f.ReadAt(hdr[:], 0); err != nil {
+			return err
+		}
+		if string(hdr[:8]) != magic || le.Uint64(hdr[8:]) != uint64(k.Bufsize) {
+			return ErrFormat
+		}
+		nblocks = le.Uint64(hdr[16:])
+	}
+	k.nslots = nblocks
+	size := hdrsize + int(nblocks)*(metasize+k.Bufsize)
+	if k.mem, err = syscall.Mmap(int(k.f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED); err != nil {
+		return err
+	}
+	if fresh {
+		copy(k.mem, magic)
+		le.PutUint64(k.mem[8:], uint64(k.Bufsize))
+		le.PutUint64(k.mem[16:], nblocks)
+		k.setfreehead(NOBLOCK)
+		for bn := Block(nblocks); bn > 0; bn-- {
+			k.setmeta(bn-1, 0, k.freehead(), 0, 0)
+			k.setfreehead(bn - 1)
+		}
+	}
+	return nil
+}
+
+func (k *Bucket_shm) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := syscall.Munmap(k.mem); err != nil {
+		return err
+	}
+	return k.f.Close()
+}
+
+/*
+ * remove the segment; processes still attached keep their mapping.
+ */
+func Unlink(name string) error {
+	return os.Remove("/dev/shm/" + name)
+}
+
+func (k *Bucket_shm) lock() {
+	k.mu.Lock()
+	syscall.Flock(int(k.f.Fd()), syscall.LOCK_EX)
+}
+
+func (k *Bucket_shm) unlock() {
+	syscall.Flock(int(k.f.Fd()), syscall.LOCK_UN)
+	k.mu.Unlock()
+}
+
+func (k *Bucket_shm) freehead() Block {
+	return Block(le.Uint64(k.mem[24:]))
+}
+
+func (k *Bucket_shm) setfreehead(bn Block) {
+	le.PutUint64(k.mem[24:], uint64(bn))
+}
+
+func (k *Bucket_shm) slot(bn Block) []byte {
+	off := hdrsize + int(bn)*(metasize+k.Bufsize)
+	return k.mem[off : off+metasize+k.Bufsize : off+metasize+k.Bufsize]
+}
+
+func (k *Bucket_shm) meta(bn Block) (gen Gen, next Block, version uint64, refs uint64) {
+	s := k.slot(bn)
+	return Gen(le.Uint64(s)), Block(le.Uint64(s[8:])), le.Uint64(s[16:]), le.Uint64(s[24:])
+}
+
+func (k *Bucket_shm) setmeta(bn Block, gen Gen, next Block, version uint64, refs uint64) {
+	s := k.slot(bn)
+	le.PutUint64(s, uint64(gen))
+	le.PutUint64(s[8:], uint64(next))
+	le.PutUint64(s[16:], version)
+	le.PutUint64(s[24:], refs)
+}
+
+func (k *Bucket_shm) data(bn Block) Buf {
+	return Buf(k.slot(bn)[metasize:])
+}
+
+func (k *Bucket_shm) alloc() (Block, error) {
+	bn := k.freehead()
+	if bn == NOBLOCK {
+		return NOBLOCK, ErrFull
+	}
+	gen, next, version, _ := k.meta(bn)
+	k.setfreehead(next)
+	k.setmeta(bn, gen, anon, version, 0)
+	return bn, nil
+}
+
+func (k *Bucket_shm) free(bn Block) {
+	gen, _, version, _ := k.meta(bn)
+	k.setmeta(bn, gen, k.freehead(), version+1, 0)
+	k.setfreehead(bn)
+}
+
+func (k *Bucket_shm) live(bn Block) bool {
+	if uint64(bn) >= k.nslots {
+		return false
+	}
+	_, next, _, _ := k.meta(bn)
+	return next == inuse
+}
+
+func (k *Bucket_shm) Keep(b *Buf, decref bool) (Block, Gen, error) {
+	k.lock()
+	defer k.unlock()
+
+	if len(*b) > k.Bufsize {
+		return NOBLOCK, 0, ErrBufsize
+	}
+	bn, mine := k.bufs[b]
+	var refs uint64
+	if mine {
+		var next Block
+		if _, next, _, refs = k.meta(bn); next != anon {
+			mine = false // a fetched block: copy into a new one
+		}
+	}
+	if !mine {
+		var err error
+		if bn, err = k.alloc(); err != nil {
+			return NOBLOCK, 0, err
+		}
+		d := k.data(bn)
+		clear(d[copy(d, *b):])
+		refs = 0
+	}
+	gen, _, version, _ := k.meta(bn)
+	gen++
+	k.setmeta(bn, gen, inuse, version+1, refs)
+	if decref {
+		return bn, gen, k.release(b)
+	}
+	return bn, gen, nil
+}
+
+/*
+ * the returned buffer aliases the segment: it must not be written unless anonymous,
+ * and may change as it is read, until the link is verified.
+ */
+func (k *Bucket_shm) Fetch(d Block, withlink bool) (*Buf, Link, error) {
+	k.lock()
+	defer k.unlock()
+
+	if d == NOBLOCK {
+		bn, err := k.alloc()
+		if err != nil {
+			return nil, NOLINK, err
+		}
+		b := k.data(bn)
+		clear(b)
+		gen, next, version, _ := k.meta(bn)
+		k.setmeta(bn, gen, next, version, 1)
+		k.bufs[&b] = bn
+		return &b, NOLINK, nil
+	}
+	if !k.live(d) {
+		return nil, NOLINK, ErrNoBlock
+	}
+	gen, next, version, refs := k.meta(d)
+	k.setmeta(d, gen, next, version, refs+1)
+	b := k.data(d)
+	k.bufs[&b] = d
+
+	l := NOLINK
+	if withlink {
+		l = Link(version)
+	}
+	return &b, l, nil
+}
+
+func (k *Bucket_shm) Gen(d Block) (Gen, error) {
+	k.lock()
+	defer k.unlock()
+
+	if !k.live(d) {
+		return 0, ErrNoBlock
+	}
+	gen, _, _, _ := k.meta(d)
+	return gen, nil
+}
+
+func (k *Bucket_shm) Replace(d Block, b *Buf, off uint, l Link, decref bool) error {
+	k.lock()
+	defer k.unlock()
+
+	live := k.live(d)
+	switch {
+	case !live && l == NOLINK:
+		return ErrNoBlock
+	case off+uint(len(*b)) > uint(k.Bufsize):
+		return ErrBufsize
+	}
+	gen, next, version, refs := k.meta(d)
+	if l != NOLINK && (!live || version != uint64(l)) {
+		return l
+	}
+	if len(*b) > 0 {
+		copy(k.data(d)[off:], *b)
+		k.setmeta(d, gen, next, version+1, refs)
+	}
+	if decref {
+		return k.release(b)
+	}
+	return nil
+}
+
+func (k *Bucket_shm) Discard(d ...Block) error {
+	k.lock()
+	defer k.unlock()
+
+	for _, bn := range d {
+		if !k.live(bn) {
+			return ErrNoBlock
+		}
+		gen, _, version, refs := k.meta(bn)
+		if refs == 0 {
+			k.free(bn)
+			continue
+		}
+		k.setmeta(bn, gen, dropped, version+1, refs)
+	}
+	return nil
+}
+
+func (k *Bucket_shm) Release(b ...*Buf) error {
+	k.lock()
+	defer k.unlock()
+
+	var err error
+	for _, buf := range b {
+		if e := k.release(buf); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+/*
+ * drop a reference to a buffer; anonymous and discarded slots are freed with their last reference.
+ */
+func (k *Bucket_shm) release(b *Buf) error {
+	bn, ok := k.bufs[b]
+	if !ok {
+		return ErrNoRef
+	}
+	delete(k.bufs, b)
+	gen, next, version, refs := k.meta(bn)
+	if refs--; refs == 0 && next != inuse {
+		k.free(bn)
+		return nil
+	}
+	k.setmeta(bn, gen, next, version, refs)
+	return nil
+}
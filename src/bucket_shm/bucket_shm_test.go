@@ -0,0 +1,90 @@
+//go:build linux
+
+package bucket_shm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"bucket"
+	"bucket/buckettest"
+)
+
+func TestBucket(t *testing.T) {
+	n := 0
+	buckettest.Run(t, func(t *testing.T) bucket.Bucket {
+		n++
+		name := fmt.Sprintf("buckettest-%v-%v", os.Getpid(), n)
+		k, err := Open(name, 256, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			k.Close()
+			os.Remove("/dev/shm/" + name)
+		})
+		return k
+	})
+}
+
+/*
+ * two attachments of one segment see each other's replaces, links and discards
+ */
+func TestShared(t *testing.T) {
+	name := fmt.Sprintf("buckettest-%v-shared", os.Getpid())
+	k1, err := Open(name, 64, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Unlink(name)
+	defer k1.Close()
+	k2, err := Open(name, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k2.Close()
+
+	b := bucket.Buf("0123")
+	bn, _, err := k1.Keep(&b, false)
+	if err != nil {
+		t.Fatalf("Keep: %v", err)
+	}
+	b1, l1, err := k1.Fetch(bn, true)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	b2, l2, err := k2.Fetch(bn, true)
+	if err != nil {
+		t.Fatalf("Fetch from the second attachment: %v", err)
+	}
+	if string((*b2)[:4]) != "0123" {
+		t.Errorf("second attachment reads %q", (*b2)[:4])
+	}
+
+	w := bucket.Buf("ab")
+	if err := k2.Replace(bn, &w, 0, l2, false); err != nil {
+		t.Fatalf("linked Replace: %v", err)
+	}
+	if string((*b1)[:4]) != "ab23" {
+		t.Errorf("first attachment reads %q after the second one's Replace", (*b1)[:4])
+	}
+	var l bucket.Link
+	if err := k1.Replace(bn, &w, 2, l1, false); !errors.As(err, &l) {
+		t.Errorf("linked Replace after the other attachment's Replace returned %v, want a Link", err)
+	}
+
+	// discarding from one attachment leaves the other's reference alone
+	if err := k1.Discard(bn); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if _, _, err := k2.Fetch(bn, false); err != bucket.ErrNoBlock {
+		t.Errorf("Fetch of a block discarded by the other attachment: %v", err)
+	}
+	if err := k2.Release(b2); err != nil {
+		t.Errorf("Release after the other attachment's Discard: %v", err)
+	}
+	if err := k1.Release(b1); err != nil {
+		t.Errorf("Release after Discard: %v", err)
+	}
+}
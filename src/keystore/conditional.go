@@ -0,0 +1,46 @@
+package keystore
+
+import "errors"
+
+/*
+ * conditional writes: a write that is to happen only if the store is in some state fails with one of these
+ * when it is not, and writes nothing.
+ * the condition is checked against the blocks as fetched (linked) for the write, which fails, and is retried,
+ * should they change before it is done. the write is a single linked Replace of the block where the key parts
+ * from the others, any blocks below it being sealed (see Replace), so it is decided at once.
+ *   InsertOptions.IfAbsent: ErrExists if the key is stored already.
+ *   ReplaceOptions.IfPresent: ErrNotFound if no stored key matches oldkey, ErrExists if newkey is stored already.
+ *   PutWith, CompareAndSwap: for a store keeping values, also ErrConflict if the value is not the one expected.
+ * ErrNotFound and ErrExists found by a lookup of their own were true as of that lookup.
+ */
+var (
+	ErrExists   = errors.New("key exists")
+	ErrNotFound = errors.New("no such key")
+	ErrConflict = errors.New("stored value is not the one expected")
+)
+
+/*
+ * the stored key matching key, in full in the exact dimensions (nil for all) and as a prefix in the others;
+ * value dimension included.
+ */
+func (k Keystore) stored(key []Key, exact []bool) ([]Key, error) {
+	matchlen := map[int]int{}
+
+	for d := range key {
+		if exact == nil || exact[d] {
+			matchlen[d] = int(key[d].Bitlen) + 1
+		}
+	}
+	c, err := k.CursorWith(key, RetrieveOptions{Matchlen: matchlen, Maxkeys: 1})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if !c.Next() {
+		if c.Err() != nil {
+			return nil, c.Err()
+		}
+		return nil, ErrNotFound
+	}
+	return c.key, nil
+}
@@ -0,0 +1,163 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+/*
+ * Insert IfAbsent fails on a stored key, and on it alone: a prefix or extension of one is absent
+ */
+func TestInsertIfAbsent(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	insert(t, k, "ab")
+
+	for _, tc := range []struct {
+		key string
+		err error
+	}{
+		{"ab", ErrExists},
+		{"a", nil},
+		{"abc", nil},
+		{"abc", ErrExists},
+	} {
+		if _, err := k.InsertWith(skeys(tc.key), InsertOptions{IfAbsent: true}); err != tc.err {
+			t.Errorf("Insert IfAbsent(%q) = %v, want %v", tc.key, err, tc.err)
+		}
+	}
+	if got := retrieveall(t, k, 1); !slices.Equal(got, []string{"a", "ab", "abc"}) {
+		t.Errorf("stored %q", got)
+	}
+}
+
+/*
+ * PutWith decides on the stored value, and writes nothing when it fails
+ */
+func TestPutConditions(t *testing.T) {
+	k, bkt := newstore(t, 128, Config{Values: true})
+	big := value(300, 5)
+
+	for _, tc := range []struct {
+		key   string
+		value []byte
+		o     PutOptions
+		err   error
+		after []byte // the value after; nil for none
+	}{
+		{"a", []byte("1"), PutOptions{IfPresent: true}, ErrNotFound, nil},
+		{"a", []byte("1"), PutOptions{Compare: true}, ErrNotFound, nil},
+		{"a", []byte("1"), PutOptions{IfAbsent: true}, nil, []byte("1")},
+		{"a", []byte("2"), PutOptions{IfAbsent: true}, ErrExists, []byte("1")},
+		{"a", []byte("2"), PutOptions{IfPresent: true}, nil, []byte("2")},
+		{"a", []byte("3"), PutOptions{Compare: true, Expect: []byte("1")}, ErrConflict, []byte("2")},
+		{"a", big, PutOptions{Compare: true, Expect: []byte("2")}, nil, big},
+		{"a", []byte("4"), PutOptions{Compare: true, Expect: big[:299]}, ErrConflict, big},
+		{"a", []byte{}, PutOptions{Compare: true, Expect: big}, nil, []byte{}},
+		{"a", []byte("5"), PutOptions{Compare: true}, nil, []byte("5")}, // an empty value is expected
+		{"a", []byte("6"), PutOptions{IfAbsent: true, IfPresent: true}, ErrInvalid, []byte("5")},
+		{"a", []byte("6"), PutOptions{IfAbsent: true, Compare: true}, ErrInvalid, []byte("5")},
+	} {
+		n := bkt.blocks()
+		err := k.PutWith(skeys(tc.key), tc.value, tc.o)
+		if err != tc.err {
+			t.Errorf("PutWith(%q, %q, %+v) = %v, want %v", tc.key, tc.value, tc.o, err, tc.err)
+		}
+		v, gerr := k.Get(skeys(tc.key))
+		if tc.after == nil && gerr != ErrNotFound || tc.after != nil && (gerr != nil || !bytes.Equal(v, tc.after)) {
+			t.Errorf("after PutWith(%q, %q, %+v): Get = %q, %v; want %q", tc.key, tc.value, tc.o, v, gerr, tc.after)
+		}
+		if err != nil && bkt.blocks() != n {
+			t.Errorf("PutWith(%q, %q, %+v) failed leaving %v blocks, not %v", tc.key, tc.value, tc.o, bkt.blocks(), n)
+		}
+	}
+	if err := k.CompareAndSwap(skeys("b"), nil, []byte("x")); err != ErrNotFound {
+		t.Errorf("CompareAndSwap of a key not stored = %v, want ErrNotFound", err)
+	}
+}
+
+/*
+ * CompareAndSwap loops incrementing a shared counter lose no increments
+ */
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	k, _ := newstore(t, 128, Config{Values: true})
+	key := skeys("counter")
+	count := func(n uint64) []byte { return binary.BigEndian.AppendUint64(nil, n) }
+	const workers, increments = 4, 25
+
+	if err := k.Put(key, count(0)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				v, err := k.Get(key)
+				if err != nil {
+					t.Errorf("Get: %v", err)
+					return
+				}
+				switch err := k.CompareAndSwap(key, v, count(binary.BigEndian.Uint64(v)+1)); err {
+				case nil:
+					i++
+				case ErrConflict:
+				default:
+					t.Errorf("CompareAndSwap: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, err := k.Get(key); err != nil || binary.BigEndian.Uint64(v) != workers*increments {
+		t.Errorf("counter = %v, %v; want %v", v, err, workers*increments)
+	}
+}
+
+/*
+ * two CompareAndSwaps of a long key that both found the value they expect, and then write at once:
+ * one wins, the other finds the value gone and fails, and only the winner's overflow blocks are left.
+ */
+func TestCompareAndSwapRace(t *testing.T) {
+	k, bkt := newstore(t, 64, Config{Values: true})
+	key := skeys(fmt.Sprintf("%0100d", 3))
+	if err := k.Put(key, value(200, 0)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	n := bkt.blocks()
+	var both sync.WaitGroup
+	both.Add(2)
+	errs := make(chan error, 2)
+
+	for w := byte(1); w <= 2; w++ {
+		kw := *k
+		kw.Bucket = &gate{Bucket: bkt, hook: func() { both.Done(); both.Wait() }}
+		go func() {
+			errs <- kw.CompareAndSwap(key, value(200, 0), value(200, w))
+		}()
+	}
+	won := 0
+	for range 2 {
+		switch err := <-errs; err {
+		case nil:
+			won++
+		case ErrConflict:
+		default:
+			t.Errorf("CompareAndSwap: %v", err)
+		}
+	}
+	v, err := k.Get(key)
+	switch {
+	case won != 1:
+		t.Errorf("%v CompareAndSwaps won, want 1", won)
+	case err != nil || !bytes.Equal(v, value(200, 1)) && !bytes.Equal(v, value(200, 2)):
+		t.Errorf("Get = %v bytes, %v; want a writer's value", len(v), err)
+	case bkt.blocks() != n:
+		t.Errorf("%v blocks left, want %v", bkt.blocks(), n)
+	}
+}
@@ -0,0 +1,95 @@
+package keystore
+
+import (
+	"errors"
+	"bucket"
+)
+
+/*
+ * what a Keystore is made of; see New.
+ */
+type Config struct {
+	Bucket      bucket.Bucket
+	Bufsize     int           // 0 for that of Bucket
+	Root        *bucket.Block // of a store made before; nil for a new, empty one
+	Compressed  bool
+	Dimpace     Dimpace // needed for more than a single dimension
+	Dims        int     // # of dimensions of the keys; 0 for any
+	Values      bool    // keep a value with each key; see Put
+	Pacer       string  // registered name of Dimpace, which is looked up by it if not given; see RegisterPacer
+	PacerParams []byte  // what Pacer is made with
+}
+
+var (
+	ErrNoBucket = errors.New("no bucket")
+	ErrNoRoot   = errors.New("no root block")
+	ErrBufsize  = errors.New("bufsize does not match the bucket, or is too small for a fork")
+)
+
+/*
+ * a Keystore on c.Bucket: Bufsize is checked against the size of the bucket's buffers, and
+ * the root block, created empty if not given, against the format.
+ * a store paced by "hilbert" is only to be had as a Hilbertstore; see CreateHilbert.
+ */
+func New(c Config) (*Keystore, error) {
+	if err := hilbertonly(c.Pacer); err != nil {
+		return nil, err
+	}
+	k, _, err := newkeystore(c)
+	return k, err
+}
+
+/*
+ * New, also returning the gen of a root block it created
+ */
+func newkeystore(c Config) (*Keystore, bucket.Gen, error) {
+	k := &Keystore{Dimpace: c.Dimpace, Bucket: c.Bucket, Root: bucket.NOBLOCK, Bufsize: c.Bufsize, Compressed: c.Compressed, Dims: c.Dims, Values: c.Values, Pacer: c.Pacer}
+	gen := bucket.Gen(0)
+	var err error
+
+	if c.Root != nil {
+		k.Root = *c.Root
+	}
+	switch {
+	case k.Bucket == nil:
+		return nil, 0, ErrNoBucket
+	case k.Dims < 0:
+		return nil, 0, ErrInvalid
+	}
+	if k.Dimpace == nil && c.Pacer != "" {
+		if k.Dimpace, err = LookupPacer(c.Pacer, c.PacerParams); err != nil {
+			return nil, 0, err
+		}
+	}
+	if k.Dims > 1 && k.Dimpace == nil {
+		return nil, 0, ErrDims
+	}
+	buf, _, err := k.Bucket.Fetch(bucket.NOBLOCK, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	if k.Bufsize == 0 {
+		k.Bufsize = len(*buf)
+	}
+	if _, width, _ := forkfan(k.Bufsize, k.Compressed); k.Bufsize != len(*buf) || width == 0 {
+		k.Bucket.Release(buf)
+		return nil, 0, ErrBufsize
+	}
+
+	if k.Root == bucket.NOBLOCK {
+		clear(*buf) // a zeroed buffer is an empty block
+		if k.Root, gen, err = k.Bucket.Keep(buf, true); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		k.Bucket.Release(buf)
+		if buf, _, err = k.Bucket.Fetch(k.Root, false); err != nil {
+			return nil, 0, err
+		}
+		_, err = demarshall((*buff)(buf), k.Compressed)
+		if k.Bucket.Release(buf); err != nil {
+			return nil, 0, err
+		}
+	}
+	return k, gen, k.Init()
+}
@@ -0,0 +1,59 @@
+package keystore
+
+import (
+	"slices"
+	"testing"
+	"bucket"
+	"bucket_mem"
+)
+
+/*
+ * a configuration that cannot make a store fails with the error telling why
+ */
+func TestNewErrors(t *testing.T) {
+	cases := []struct {
+		what string
+		c    Config
+		err  error
+	}{
+		{"no bucket", Config{}, ErrNoBucket},
+		{"bufsize not the bucket's", Config{Bucket: bucket_mem.New(64), Bufsize: 128}, ErrBufsize},
+		{"bufsize too small for a fork", Config{Bucket: bucket_mem.New(4)}, ErrBufsize},
+		{"dimensions without a Dimpace", Config{Bucket: bucket_mem.New(64), Dims: 2}, ErrDims},
+		{"negative dimensions", Config{Bucket: bucket_mem.New(64), Dims: -1}, ErrInvalid},
+	}
+	for _, c := range cases {
+		if _, err := New(c.c); err != c.err {
+			t.Errorf("New with %v = %v, want %v", c.what, err, c.err)
+		}
+	}
+
+	bkt := bucket_mem.New(64)
+	nothing := bucket.Block(12345)
+	if _, err := New(Config{Bucket: bkt, Root: &nothing}); err != bucket.ErrNoBlock {
+		t.Errorf("New with a root not in the bucket = %v, want %v", err, bucket.ErrNoBlock)
+	}
+}
+
+/*
+ * a store made again on its root has the keys stored before, and works out the same fork parameters
+ */
+func TestNewRoot(t *testing.T) {
+	k, bkt := newstore(t, 64, Config{})
+	if k.forkfanout == 0 || k.forkwidth == 0 {
+		t.Errorf("New left forkfanout %v, forkwidth %v", k.forkfanout, k.forkwidth)
+	}
+	insert(t, k, "a", "b", "c")
+	root := k.Root
+
+	again, err := New(Config{Bucket: bkt, Root: &root, Bufsize: 64})
+	if err != nil {
+		t.Fatalf("New on the root: %v", err)
+	}
+	if again.forkfanout != k.forkfanout || again.forkwidth != k.forkwidth {
+		t.Errorf("fork parameters %v/%v, were %v/%v", again.forkfanout, again.forkwidth, k.forkfanout, k.forkwidth)
+	}
+	if got := retrieveall(t, again, 1); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("stored %q", got)
+	}
+}
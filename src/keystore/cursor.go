@@ -0,0 +1,226 @@
+package keystore
+
+import (
+	"encoding/binary"
+	"iter"
+	"bucket"
+)
+
+/*
+ * a Cursor hands out the keys Retrieve would, one at a time, walking the store as it goes.
+ * it holds the parsed blocks of the branches it has yet to walk, but no buffer references:
+ * blocks are released as soon as they are parsed.
+ * a walk that loses a race with a writer picks up past the last key handed out, as does a cursor
+ * made with the Token of another: keys stored or deleted meanwhile may or may not show.
+ */
+type Cursor struct {
+	w   walker
+	key []Key
+	err error
+}
+
+/*
+ * opaque position of a cursor, to resume a walk with the same arguments from; see Cursor.Token.
+ */
+type Token []byte
+
+/*
+ * Cursor(key []Key, shorthand bool, matchlen map[int] int, reverse []bool, maxkeys int, from Token) (*Cursor, error)
+ * arguments are as for Retrieve; from (optional) resumes past the key the cursor it was taken from was at.
+ */
+func (k Keystore) Cursor(key []Key, more ...interface{}) (*Cursor, error) {
+	o, err := retrieveargs(more)
+	if err != nil {
+		return nil, err
+	}
+	return k.CursorWith(key, o)
+}
+
+func (k Keystore) CursorWith(key []Key, o RetrieveOptions) (*Cursor, error) {
+	if err := k.checkkey(key); err != nil {
+		return nil, err
+	}
+	if err := o.check(key); err != nil {
+		return nil, err
+	}
+	if k.Values {
+		if o.Shorthand {
+			return nil, ErrInvalid
+		}
+		key = withvalue(key, Key{}) // any value
+	}
+
+	c := &Cursor{w: walker{state: searchstate{k: &k}, key: key, matchlen: make([]uint, len(key)), reverse: make([]bool, len(key)), maxkeys: o.Maxkeys}}
+	w := &c.w
+	if o.Shorthand {
+		w.shorthand, w.maxkeys = true, 2 // a second key makes it ambiguous
+	}
+	copy(w.reverse, o.Reverse)
+	for d := range key {
+		m, ok := o.Matchlen[d]
+		switch {
+		case !ok:
+			w.matchlen[d] = key[d].Bitlen
+		case m > int(key[d].Bitlen):
+			w.matchlen[d] = key[d].Bitlen + 1
+		case m > 0:
+			w.matchlen[d] = uint(m)
+		}
+	}
+	if o.From != nil {
+		after, ok := o.From.key()
+		if !ok || len(after) != len(key) {
+			return nil, ErrInvalid
+		}
+		w.after = after
+	}
+	if err := w.start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+/*
+ * advance to the next key; false once there are no more, or on error.
+ * a shorthand walk looks a key ahead: a shorthand matching a second key fails with an *AmbiguityError.
+ */
+func (c *Cursor) Next() bool {
+	c.key = c.step()
+	if c.key != nil && c.w.shorthand {
+		if more := c.step(); more != nil {
+			c.err = &AmbiguityError{Keys: [][]Key{c.key, more}}
+		}
+	}
+	if c.err != nil {
+		c.key = nil
+	}
+	return c.key != nil
+}
+
+/*
+ * the next key of the walk; nil once there are no more, or on error.
+ */
+func (c *Cursor) step() []Key {
+	for c.err == nil {
+		key, err := c.w.next()
+		switch _, lost := err.(bucket.Link); {
+		case lost:
+			c.err = c.w.start() // pick up past the last key handed out
+		case err != nil:
+			c.err = err
+		case key == nil:
+			return nil
+		default:
+			c.w.after = key
+			return key
+		}
+	}
+	return nil
+}
+
+/*
+ * the key Next advanced to; see Value for its value.
+ */
+func (c *Cursor) Key() []Key {
+	if c.key != nil && c.w.state.k.Values {
+		return c.key[:len(c.key)-1]
+	}
+	return c.key
+}
+
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+/*
+ * position past the key handed out last, or nil if none was.
+ */
+func (c *Cursor) Token() Token {
+	if c.w.after == nil {
+		return nil
+	}
+	t := binary.AppendUvarint(nil, uint64(len(c.w.after)))
+	for _, k := range c.w.after {
+		t = append(binary.AppendUvarint(t, uint64(k.Bitlen)), k.Bytes()...)
+	}
+	return t
+}
+
+func (t Token) key() ([]Key, bool) {
+	n, l := binary.Uvarint(t)
+	if l <= 0 || n > uint64(len(t)) {
+		return nil, false
+	}
+	t = t[l:]
+	key := make([]Key, n)
+	for d := range key {
+		bitlen, l := binary.Uvarint(t)
+		nbytes := (bitlen + 7) / 8
+		if l <= 0 || nbytes > uint64(len(t)-l) {
+			return nil, false
+		}
+		key[d] = FromBytes(t[l:l+int(nbytes)]).Substr(0, uint(bitlen))
+		t = t[l+int(nbytes):]
+	}
+	return key, len(t) == 0
+}
+
+/*
+ * drop what is left of the walk; Next returns false from then on.
+ */
+func (c *Cursor) Close() error {
+	c.w.todo, c.key = nil, nil
+	return nil
+}
+
+/*
+ * the keys a Cursor with the same arguments would hand out, for range loops; a failing walk yields its error last.
+ * each loop walks afresh, and a loop left early closes its cursor.
+ */
+func (k Keystore) Scan(key []Key, more ...interface{}) iter.Seq2[[]Key, error] {
+	o, err := retrieveargs(more)
+	if err != nil {
+		return func(yield func([]Key, error) bool) { yield(nil, err) }
+	}
+	return k.ScanWith(key, o)
+}
+
+func (k Keystore) ScanWith(key []Key, o RetrieveOptions) iter.Seq2[[]Key, error] {
+	return func(yield func([]Key, error) bool) {
+		c, err := k.CursorWith(key, o)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer c.Close()
+		for c.Next() {
+			if !yield(c.Key(), nil) {
+				return
+			}
+		}
+		if c.Err() != nil {
+			yield(nil, c.Err())
+		}
+	}
+}
+
+/*
+ * Scan in the other direction: in each dimension, backwards unless reverse says so.
+ */
+func (k Keystore) ScanReverse(key []Key, more ...interface{}) iter.Seq2[[]Key, error] {
+	o, err := retrieveargs(more)
+	if err != nil {
+		return func(yield func([]Key, error) bool) { yield(nil, err) }
+	}
+	return k.ScanReverseWith(key, o)
+}
+
+func (k Keystore) ScanReverseWith(key []Key, o RetrieveOptions) iter.Seq2[[]Key, error] {
+	reverse := make([]bool, max(len(key), len(o.Reverse)))
+
+	for d := range reverse {
+		reverse[d] = d >= len(o.Reverse) || !o.Reverse[d]
+	}
+	o.Reverse = reverse
+	return k.ScanWith(key, o)
+}
@@ -0,0 +1,200 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"bucket"
+)
+
+/*
+ * a page of keys from key on, as o says, resumed from a token, and the token to go on from
+ */
+func page(t *testing.T, k *Keystore, key string, o RetrieveOptions, from Token) ([]string, Token) {
+	t.Helper()
+	o.From = from
+	c, err := k.CursorWith(skeys(key), o)
+	if err != nil {
+		t.Fatalf("Cursor: %v", err)
+	}
+	keys := [][]Key{}
+	for c.Next() {
+		keys = append(keys, c.Key())
+	}
+	if c.Err() != nil {
+		t.Fatalf("Next: %v", c.Err())
+	}
+	c.Close()
+	if c.Next() {
+		t.Errorf("Next after Close")
+	}
+	return kstrings(keys), c.Token()
+}
+
+/*
+ * paging with tokens hands out every key once, in order, either way round
+ */
+func TestCursorPages(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	want := []string{}
+	for i := 0; i < 50; i++ {
+		want = append(want, fmt.Sprintf("k%02d", i))
+	}
+	insert(t, k, want...)
+
+	for _, reverse := range []bool{false, true} {
+		o, from := RetrieveOptions{Matchlen: map[int]int{0: 0}, Maxkeys: 7}, ""
+		if reverse {
+			o.Reverse, from = []bool{true}, "z"
+		}
+		got, tok := []string{}, Token(nil)
+		for pages := 0; ; pages++ {
+			keys, next := page(t, k, from, o, tok)
+			if len(keys) == 0 {
+				break
+			}
+			if pages > 50 {
+				t.Fatalf("paging does not end")
+			}
+			got, tok = append(got, keys...), next
+		}
+		w := slices.Clone(want)
+		if reverse {
+			slices.Reverse(w)
+		}
+		if !slices.Equal(got, w) {
+			t.Errorf("reverse %v: paged %q, want %q", reverse, got, w)
+		}
+	}
+}
+
+/*
+ * a token carries over to a store changed meanwhile: the walk picks up past its key, stored or not
+ */
+func TestCursorResume(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	insert(t, k, "a", "b", "c", "d")
+
+	keys, tok := page(t, k, "", RetrieveOptions{Matchlen: map[int]int{0: 0}, Maxkeys: 2}, nil)
+	if !slices.Equal(keys, []string{"a", "b"}) {
+		t.Fatalf("first page %q", keys)
+	}
+	if err := k.Delete(skeys("b"), []bool{true}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	insert(t, k, "aa", "bb")
+	if keys, _ = page(t, k, "", RetrieveOptions{Matchlen: map[int]int{0: 0}}, tok); !slices.Equal(keys, []string{"bb", "c", "d"}) {
+		t.Errorf("resumed with %q, want %q", keys, []string{"bb", "c", "d"})
+	}
+
+	c, err := k.Cursor(make([]Key, 1), map[int]int{0: 0})
+	if err != nil {
+		t.Fatalf("Cursor: %v", err)
+	}
+	if c.Token() != nil {
+		t.Errorf("token of a cursor that handed out nothing")
+	}
+	for _, bad := range []Token{{}, {1}, {1, 200}, append(tok, 0), {2, 0}} {
+		if _, err := k.CursorWith(make([]Key, 1), RetrieveOptions{From: bad}); err != ErrInvalid {
+			t.Errorf("Cursor from token %v = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
+
+/*
+ * a bucket that counts the buffer references handed out and not given back
+ */
+type holding struct {
+	bucket.Bucket
+	mu   sync.Mutex
+	refs int
+}
+
+func (h *holding) add(n int) {
+	h.mu.Lock()
+	h.refs += n
+	h.mu.Unlock()
+}
+
+func (h *holding) Fetch(d bucket.Block, withlink bool) (*bucket.Buf, bucket.Link, error) {
+	buf, l, err := h.Bucket.Fetch(d, withlink)
+	if err == nil {
+		h.add(1)
+	}
+	return buf, l, err
+}
+
+func (h *holding) Keep(b *bucket.Buf, decref bool) (bucket.Block, bucket.Gen, error) {
+	if decref {
+		h.add(-1)
+	}
+	return h.Bucket.Keep(b, decref)
+}
+
+func (h *holding) Replace(d bucket.Block, b *bucket.Buf, off uint, l bucket.Link, decref bool) error {
+	if decref {
+		h.add(-1)
+	}
+	return h.Bucket.Replace(d, b, off, l, decref)
+}
+
+func (h *holding) Release(b ...*bucket.Buf) error {
+	h.add(-len(b))
+	return h.Bucket.Release(b...)
+}
+
+/*
+ * scans hand out what Retrieve does, the reverse one the other way round; leaving early holds nothing
+ */
+func TestScan(t *testing.T) {
+	bkt := &holding{Bucket: newcounting(64)}
+	k, err := New(Config{Bucket: bkt})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		insert(t, k, fmt.Sprintf("k%02d", i))
+	}
+	want := retrieveall(t, k, 1)
+
+	scan := func(seq func(func([]Key, error) bool), n int) []string {
+		keys := [][]Key{}
+		for key, err := range seq {
+			if err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			if keys = append(keys, key); len(keys) == n {
+				break
+			}
+		}
+		return kstrings(keys)
+	}
+	if got := scan(k.Scan(make([]Key, 1), map[int]int{0: 0}), 0); !slices.Equal(got, want) {
+		t.Errorf("Scan: %q, want %q", got, want)
+	}
+	slices.Reverse(want)
+	if got := scan(k.ScanReverse(skeys("z"), map[int]int{0: 0}), 0); !slices.Equal(got, want) {
+		t.Errorf("ScanReverse: %q, want %q", got, want)
+	}
+	if got := scan(k.ScanReverseWith(skeys("k20"), RetrieveOptions{Matchlen: map[int]int{0: 0}, Reverse: []bool{true}}), 0); len(got) != 20 || got[0] != "k20" {
+		t.Errorf("ScanReverse, reversed back: %q", got)
+	}
+	if got := scan(k.Scan(make([]Key, 1), map[int]int{0: 0}), 5); len(got) != 5 {
+		t.Errorf("Scan left after 5 keys handed out %v", len(got))
+	}
+	if bkt.refs != 0 {
+		t.Errorf("%v buffer references held after scanning", bkt.refs)
+	}
+
+	yields := 0
+	for _, err := range k.Scan(make([]Key, 1), "7") {
+		if yields++; !errors.Is(err, ErrInvalid) {
+			t.Errorf("Scan with a string argument yields %v, want ErrInvalid", err)
+		}
+	}
+	if yields != 1 {
+		t.Errorf("Scan with a string argument yields %v times", yields)
+	}
+}
@@ -0,0 +1,271 @@
+package keystore
+
+import (
+	"errors"
+	"slices"
+	"bucket"
+)
+
+/*
+ * Delete walks down to the first spot where key is matched in all dimensions (exact ones up to and including
+ * their stop): every stored key below it goes. the branch holding the spot is cut off the nearest fork above,
+ * the blocks it spans are discarded, and a fork left with a single branch is merged back into a string.
+ * keys whose non-exact dimensions do not exhaust together may leave several such spots; these are cut one at a time.
+ * before the cut is written, the blocks it spans are sealed: emptied by writes linked to what was checked, so that
+ * a write below that gets in first fails the seal, and one that comes later finds an empty block and starts over.
+ * should the cut itself fail, they get their contents back. a crash in between leaves them sealed for good.
+ * a fork merged this way no longer tells its branches apart by shorthandmatch, so shorthands of the keys
+ * below it may stop resolving.
+ */
+type deleter struct {
+	state searchstate
+	key   []Key
+	exact []bool
+}
+
+/*
+ * the fork above the spot: segment fork of b ends with it, and its branch e is to go.
+ * b is nil if there is no fork above: everything goes.
+ */
+type cut struct {
+	b       *block
+	depth   int // of b on rempath
+	fork, e int
+}
+
+func (k Keystore) Delete(key []Key, more ...[]bool) error {
+	exact, err := exactargs(more)
+	if err != nil {
+		return err
+	}
+	return k.DeleteWith(key, DeleteOptions{Exact: exact})
+}
+
+func (k Keystore) DeleteWith(key []Key, o DeleteOptions) error {
+	if err := k.checkkey(key); err != nil {
+		return err
+	}
+	if err := checkexact(key, o.Exact); err != nil {
+		return err
+	}
+
+	exact := make([]bool, len(key))
+	for d := range key {
+		exact[d] = d < len(o.Exact) && o.Exact[d]
+	}
+	if k.Values {
+		return k.deletevalues(key, exact)
+	}
+	_, err := k.deletekey(key, exact)
+	return err
+}
+
+/*
+ * cut until there is nothing left to; true if anything was.
+ */
+func (k Keystore) deletekey(key []Key, exact []bool) (bool, error) {
+	dl := deleter{state: searchstate{k: &k}, key: key, exact: exact}
+
+	for cuts := 0; ; {
+		done, err := dl.delete()
+		switch _, lost := err.(bucket.Link); {
+		case err == nil && done:
+			return cuts > 0, nil
+		case err == nil:
+			cuts++
+		case !lost:
+			return cuts > 0, err
+		}
+	}
+}
+
+/*
+ * a single cut; true if there was nothing (left) to cut.
+ */
+func (dl *deleter) delete() (bool, error) {
+	k := dl.state.k
+	n := len(dl.key)
+
+	dl.state.rempath = nil
+	root, err := dl.state.load(remote{bn: k.Root})
+	if err != nil || len(root.seg) == 0 {
+		return true, err
+	}
+	p := &pacer{key: make([]Key, n), dim: k.Dimpace, keybit: make([]int, n), stopmap: make(map[uint]uint), values: k.Values}
+	c, err := dl.find(root, 0, p, cut{})
+	if err != nil || c == nil {
+		return c == nil, err
+	}
+
+	b, seg := c.b, 0
+	if b == nil {
+		b, c.depth = root, 0
+	} else {
+		seg = int(b.seg[c.fork].f.fe[c.e].segidx)
+	}
+	dl.state.rempath = dl.state.rempath[:c.depth+1]
+	gone, err := k.spanned(b, seg, nil)
+	if err != nil {
+		if l := dl.state.rempath[c.depth].link; modified(k.Bucket, b.address, l) {
+			return false, l
+		}
+		return false, err
+	}
+	olds, err := k.seal(gone)
+	if err != nil {
+		return false, err
+	}
+	if c.b == nil {
+		b.seg = nil
+	} else {
+		b.unbranch(c.fork, c.e)
+	}
+	if err = dl.state.commit(b); err != nil {
+		k.unseal(gone, olds)
+		return false, err
+	}
+	if len(gone) > 0 {
+		bns := []bucket.Block{}
+		for _, r := range gone {
+			bns = append(bns, r.rem.bn)
+		}
+		err = k.Bucket.Discard(bns...)
+	}
+	return false, err
+}
+
+/*
+ * every dimension matched as far as it needs to be
+ */
+func (dl *deleter) matched(p *pacer) bool {
+	for d, k := range dl.key {
+		if i := uint(p.keybit[d]); i < k.Bitlen || (dl.exact[d] && i == k.Bitlen) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * depth first from segment i of b, for the first spot where key is matched; c is the fork above.
+ */
+func (dl *deleter) find(b *block, i int, p *pacer, c cut) (*cut, error) {
+	seg := &b.seg[i]
+
+	for _, sym := range seg.syms {
+		if dl.matched(p) {
+			return &c, nil
+		}
+		if p.done() {
+			return nil, ErrCorrupt
+		}
+		d, last, err := p.nextdim()
+		switch {
+		case err != nil:
+			return nil, err
+		case last && sym != symstop:
+			return nil, ErrCorrupt
+		}
+		switch j := uint(p.keybit[d]); {
+		case j < dl.key[d].Bitlen && sym != dl.key[d].bit(j):
+			return nil, nil
+		case j == dl.key[d].Bitlen && dl.exact[d] && sym != symstop:
+			return nil, nil
+		}
+		if sym != symstop {
+			p.key[d].append(sym)
+		}
+		p.take(d, sym)
+	}
+	switch {
+	case dl.matched(p):
+		return &c, nil
+	case seg.has_remote:
+		nb, err := dl.state.load(seg.r)
+		if err != nil {
+			return nil, err
+		}
+		found, err := dl.find(nb, 0, p, c)
+		if found == nil || err != nil {
+			dl.state.rempath = dl.state.rempath[:len(dl.state.rempath)-1]
+		}
+		return found, err
+	case seg.has_fork:
+		for e, fe := range seg.f.fe {
+			found, err := dl.find(b, int(fe.segidx), p.clone(), cut{b: b, depth: len(dl.state.rempath) - 1, fork: i, e: e})
+			if found != nil || err != nil {
+				return found, err
+			}
+		}
+		return nil, nil
+	}
+	return nil, ErrCorrupt // a whole key would have matched
+}
+
+/*
+ * the blocks hanging off segment i of b and below, with links
+ */
+func (k Keystore) spanned(b *block, i int, blocks []remcomp) ([]remcomp, error) {
+	seg := &b.seg[i]
+
+	switch {
+	case seg.has_remote:
+		buf, link, err := k.Bucket.Fetch(seg.r.bn, true)
+		if err != nil {
+			return blocks, err
+		}
+		nb, err := k.parse(seg.r.bn, buf, link)
+		k.Bucket.Release(buf)
+		if err != nil {
+			return blocks, err
+		}
+		if len(nb.seg) == 0 { // sealed by another cut
+			return blocks, link
+		}
+		return k.spanned(nb, 0, append(blocks, remcomp{rem: seg.r, link: link}))
+	case seg.has_fork:
+		for _, e := range seg.f.fe {
+			var err error
+			if blocks, err = k.spanned(b, int(e.segidx), blocks); err != nil {
+				return blocks, err
+			}
+		}
+	}
+	return blocks, nil
+}
+
+/*
+ * empty the blocks about to be cut off, top down; returns their contents, for unseal.
+ * a link error if one was written, or discarded, since it was checked.
+ */
+func (k Keystore) seal(gone []remcomp) ([]bucket.Buf, error) {
+	olds := []bucket.Buf{}
+
+	for i, r := range gone {
+		buf, _, err := k.Bucket.Fetch(r.rem.bn, false)
+		if err == nil {
+			olds = append(olds, slices.Clone(*buf))
+			k.Bucket.Release(buf)
+			empty := make(bucket.Buf, k.Bufsize)
+			err = k.Bucket.Replace(r.rem.bn, &empty, 0, r.link, false)
+		}
+		if err != nil {
+			k.unseal(gone[:i], olds)
+			if errors.Is(err, bucket.ErrNoBlock) || modified(k.Bucket, r.rem.bn, r.link) {
+				return nil, r.link
+			}
+			return nil, err
+		}
+	}
+	return olds, nil
+}
+
+/*
+ * put sealed blocks back as they were, bottom up. nobody else writes a sealed block: a link to it from before
+ * the seal has expired, and one taken since is of an empty block, which fetch turns away.
+ */
+func (k Keystore) unseal(gone []remcomp, olds []bucket.Buf) {
+	for i := len(gone) - 1; i >= 0; i-- {
+		k.Bucket.Replace(gone[i].rem.bn, &olds[i], 0, bucket.NOLINK, false)
+	}
+}
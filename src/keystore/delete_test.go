@@ -0,0 +1,209 @@
+package keystore
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"bucket"
+)
+
+/*
+ * an exact dimension takes out the key alone; one left out of Exact, or all of them, every key it is a prefix of
+ */
+func TestDelete(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	insert(t, k, "a", "ab", "abc", "abd", "b", "ba")
+
+	steps := []struct {
+		key   string
+		exact []bool
+		want  []string
+	}{
+		{"ab", []bool{true}, []string{"a", "abc", "abd", "b", "ba"}},
+		{"ab", []bool{true}, []string{"a", "abc", "abd", "b", "ba"}}, // nothing to take out
+		{"abc", []bool{false}, []string{"a", "abd", "b", "ba"}},
+		{"b", nil, []string{"a", "abd"}},
+		{"a", nil, []string{}},
+	}
+	for _, s := range steps {
+		if err := k.DeleteWith(skeys(s.key), DeleteOptions{Exact: s.exact}); err != nil {
+			t.Fatalf("Delete(%q, %v): %v", s.key, s.exact, err)
+		}
+		if got := retrieveall(t, k, 1); !slices.Equal(got, s.want) {
+			t.Errorf("after Delete(%q, %v): %q, want %q", s.key, s.exact, got, s.want)
+		}
+	}
+	if err := k.Delete(skeys("a"), []bool{true}, []bool{true}); err != ErrInvalid {
+		t.Errorf("Delete with two Exact = %v, want ErrInvalid", err)
+	}
+}
+
+/*
+ * a fork left with one branch goes back to a string, which takes new branches as any other
+ */
+func TestDeleteFork(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	insert(t, k, "aa", "ab")
+
+	if err := k.Delete(skeys("ab"), []bool{true}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	insert(t, k, "ac", "a")
+	if got, want := retrieveall(t, k, 1), []string{"a", "aa", "ac"}; !slices.Equal(got, want) {
+		t.Errorf("stored %q, want %q", got, want)
+	}
+}
+
+/*
+ * the blocks a cut spans are discarded, down to the root of an empty store
+ */
+func TestDeleteReclaims(t *testing.T) {
+	k, bkt := newstore(t, 64, Config{})
+	root := bkt.blocks()
+
+	for i := 0; i < 200; i++ {
+		insert(t, k, fmt.Sprintf("x%03d", i), fmt.Sprintf("y%03d", i))
+	}
+	full := bkt.blocks()
+	if err := k.Delete(skeys("y")); err != nil {
+		t.Fatalf("Delete(y): %v", err)
+	}
+	if n := bkt.blocks(); n >= full {
+		t.Errorf("%v blocks after taking out half the keys, %v before", n, full)
+	}
+	if got := retrieveall(t, k, 1); len(got) != 200 || got[0] != "x000" || got[199] != "x199" {
+		t.Errorf("kept %v keys, %q...", len(got), got[:min(len(got), 3)])
+	}
+	if err := k.Delete(skeys("")); err != nil {
+		t.Fatalf("Delete of everything: %v", err)
+	}
+	if n := bkt.blocks(); n != root {
+		t.Errorf("%v blocks left in an empty store, want %v", n, root)
+	}
+}
+
+/*
+ * cuts racing with writes into the blocks they span: nothing is lost but what was deleted,
+ * and no block is left behind hanging off one that was discarded.
+ */
+func TestDeleteConcurrent(t *testing.T) {
+	k, bkt := newstore(t, 64, Config{})
+	root := bkt.blocks()
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 300; i++ {
+			if _, err := k.Insert(skeys(fmt.Sprintf("x%03d", i))); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 300; i++ {
+			if _, err := k.Insert(skeys(fmt.Sprintf("y%03d", i))); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 30; i++ {
+			if err := k.Delete(skeys(fmt.Sprintf("y%d", i%3))); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	got := retrieveall(t, k, 1)
+	for i := 0; i < 300; i++ {
+		if s := fmt.Sprintf("x%03d", i); !slices.Contains(got, s) {
+			t.Errorf("%q lost", s)
+		}
+	}
+	if err := k.Delete(skeys("")); err != nil {
+		t.Fatalf("Delete of everything: %v", err)
+	}
+	if n := bkt.blocks(); n != root {
+		t.Errorf("%v blocks left in an empty store, want %v", n, root)
+	}
+}
+
+/*
+ * a view of a bucket that runs hook once, before the first write through it, or with discards the first discard
+ */
+type gate struct {
+	bucket.Bucket
+	once     sync.Once
+	hook     func()
+	discards bool
+}
+
+func (g *gate) Replace(d bucket.Block, b *bucket.Buf, off uint, l bucket.Link, decref bool) error {
+	if len(*b) > 0 && !g.discards {
+		g.once.Do(g.hook)
+	}
+	return g.Bucket.Replace(d, b, off, l, decref)
+}
+
+func (g *gate) Discard(d ...bucket.Block) error {
+	if g.discards {
+		g.once.Do(g.hook)
+	}
+	return g.Bucket.Discard(d...)
+}
+
+/*
+ * an insert that looked its block up before a cut took it off, and writes it once the cut is in:
+ * the write must not land in a block on its way out, which would lose the blocks it spilled into.
+ */
+func TestDeleteLateWrite(t *testing.T) {
+	k, bkt := newstore(t, 64, Config{})
+	root := bkt.blocks()
+	for i := 0; i < 100; i++ {
+		insert(t, k, fmt.Sprintf("x%03d", i), fmt.Sprintf("y%03d", i))
+	}
+	long := fmt.Sprintf("y050%0100d", 0) // spills whatever block it goes to
+	ready, proceed, written := make(chan bool), make(chan bool), make(chan bool)
+
+	ins, del := *k, *k
+	ins.Bucket = &gate{Bucket: bkt, hook: func() { ready <- true; <-proceed }}
+	del.Bucket = &gate{Bucket: bkt, hook: func() { proceed <- true; <-written }, discards: true}
+	errs := make(chan error, 2)
+	go func() {
+		_, err := ins.Insert(skeys(long))
+		written <- true
+		errs <- err
+	}()
+	<-ready
+	go func() {
+		errs <- del.Delete(skeys("y"))
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := retrieveall(t, k, 1); len(got) != 100 || got[99] != "x099" {
+		t.Errorf("%v keys left, want the 100 x ones", len(got))
+	}
+	if err := k.Delete(skeys("")); err != nil {
+		t.Fatalf("Delete of everything: %v", err)
+	}
+	if n := bkt.blocks(); n != root {
+		t.Errorf("%v blocks left in an empty store, want %v", n, root)
+	}
+}
@@ -0,0 +1,212 @@
+package keystore
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+ * keys for typed values, such that keys compare (bit by bit, see symorder) the way the values do, and Retrieve
+ * ranges over typed data come out in order.
+ * numbers are of fixed width, most significant bit first: ints have the sign bit flipped, floats are in IEEE
+ * total order (-NaN < -Inf < ... < -0 < +0 < ... < +Inf < +NaN).
+ * strings and byte slices sort as is: a key ending (its stop) sorts before any bit, so a prefix comes first.
+ * escaped, they can be followed by more in the same dimension: each 0x00 becomes 0x00 0xff,
+ * and 0x00 0x01 ends them, which keeps both the order and where they end.
+ * decoding a key that was not made by the matching encoder fails with ErrInvalid.
+ */
+
+const (
+	timebits = 64 + 30 // seconds and nanoseconds
+	escbyte  = 0x00
+	escquote = 0xff
+	escend   = 0x01
+)
+
+func Uintkey(v uint64) Key {
+	return appendbits(Key{}, v, 64)
+}
+
+func Keyuint(k Key) (uint64, error) {
+	if err := keylen(k, 64); err != nil {
+		return 0, err
+	}
+	return k.bits(0, 64), nil
+}
+
+func Intkey(v int64) Key {
+	return appendbits(Key{}, uint64(v)^1<<63, 64)
+}
+
+func Keyint(k Key) (int64, error) {
+	u, err := Keyuint(k)
+	if err != nil {
+		return 0, err
+	}
+	return int64(u ^ 1<<63), nil
+}
+
+func Float64key(f float64) Key {
+	return appendbits(Key{}, floatorder(math.Float64bits(f)), 64)
+}
+
+func Keyfloat64(k Key) (float64, error) {
+	u, err := Keyuint(k)
+	if err != nil {
+		return 0, err
+	}
+	if u&(1<<63) != 0 {
+		u ^= 1 << 63
+	} else {
+		u = ^u
+	}
+	return math.Float64frombits(u), nil
+}
+
+func floatorder(u uint64) uint64 {
+	if u&(1<<63) != 0 {
+		return ^u
+	}
+	return u ^ 1<<63
+}
+
+/*
+ * seconds since the epoch as Intkey does, then the nanoseconds in 30 bits; the location is not kept.
+ */
+func Timekey(t time.Time) Key {
+	return appendbits(appendbits(Key{}, uint64(t.Unix())^1<<63, 64), uint64(t.Nanosecond()), timebits-64)
+}
+
+/*
+ * the time a Timekey was made of, in UTC
+ */
+func Keytime(k Key) (time.Time, error) {
+	if err := keylen(k, timebits); err != nil {
+		return time.Time{}, err
+	}
+	sec, nsec := k.bits(0, 64), k.bits(64, timebits-64)
+	if nsec >= 1e9 {
+		return time.Time{}, fmt.Errorf("%w: %v nanoseconds", ErrInvalid, nsec)
+	}
+	return time.Unix(int64(sec^1<<63), int64(nsec)).UTC(), nil
+}
+
+func Boolkey(b bool) Key {
+	if b {
+		return appendbits(Key{}, 1, 1)
+	}
+	return appendbits(Key{}, 0, 1)
+}
+
+func Keybool(k Key) (bool, error) {
+	if err := keylen(k, 1); err != nil {
+		return false, err
+	}
+	return k.bit(0) == 1, nil
+}
+
+/*
+ * the bits of b; escaped (see above) if escape is set
+ */
+func Byteskey(b []byte, escape bool) Key {
+	return appendbytes(Key{}, b, escape)
+}
+
+func Keybytes(k Key, escaped bool) ([]byte, error) {
+	b, n, err := decodebytes(k, 0, escaped)
+	switch {
+	case err != nil:
+		return nil, err
+	case n != k.Bitlen:
+		return nil, fmt.Errorf("%w: %v bits past the end of the bytes", ErrInvalid, k.Bitlen-n)
+	}
+	return b, nil
+}
+
+func Stringkey(s string, escape bool) Key {
+	return Byteskey([]byte(s), escape)
+}
+
+func Keystring(k Key, escaped bool) (string, error) {
+	b, err := Keybytes(k, escaped)
+	return string(b), err
+}
+
+/*
+ * k with the low n bits of v appended, most significant first
+ */
+func appendbits[E Keyelems](k Keyof[E], v uint64, n uint) Keyof[E] {
+	for i := n; i > 0; i-- {
+		k.append(uint8(v>>(i-1)) & 1)
+	}
+	return k
+}
+
+func appendbytes(k Key, b []byte, escape bool) Key {
+	for _, c := range b {
+		k = appendbits(k, uint64(c), 8)
+		if escape && c == escbyte {
+			k = appendbits(k, escquote, 8)
+		}
+	}
+	if escape {
+		k = appendbits(appendbits(k, escbyte, 8), escend, 8)
+	}
+	return k
+}
+
+/*
+ * the n (up to 64) bits of k from off on, as a number; k has to hold them.
+ */
+func (k Keyof[E]) bits(off, n uint) uint64 {
+	v := uint64(0)
+
+	for i := off; i < off+n; i++ {
+		v = v<<1 | uint64(k.bit(i))
+	}
+	return v
+}
+
+/*
+ * keys of fixed width types have to be exactly that long
+ */
+func keylen(k Key, n uint) error {
+	if !k.valid() || k.Bitlen != n {
+		return fmt.Errorf("%w: key of %v bits, not %v", ErrInvalid, k.Bitlen, n)
+	}
+	return nil
+}
+
+/*
+ * the bytes at bit off of k, and where they end: the end of the key, or past the end marker if escaped.
+ */
+func decodebytes(k Key, off uint, escaped bool) ([]byte, uint, error) {
+	b := []byte{}
+
+	if !k.valid() || off > k.Bitlen {
+		return nil, off, ErrInvalid
+	}
+	for ; off+8 <= k.Bitlen; off += 8 {
+		c := byte(k.bits(off, 8))
+		if !escaped || c != escbyte {
+			b = append(b, c)
+			continue
+		}
+		if off += 8; off+8 > k.Bitlen {
+			break
+		}
+		switch k.bits(off, 8) {
+		case escquote:
+			b = append(b, escbyte)
+		case escend:
+			return b, off + 8, nil
+		default:
+			return nil, off, fmt.Errorf("%w: bad escape at bit %v", ErrInvalid, off)
+		}
+	}
+	if escaped || off != k.Bitlen {
+		return nil, off, fmt.Errorf("%w: bytes cut short at bit %v", ErrInvalid, off)
+	}
+	return b, off, nil
+}
@@ -0,0 +1,141 @@
+package keystore
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+/*
+ * keys of values given in ascending order compare in that order
+ */
+func ascending[T any](t *testing.T, name string, values []T, enc func(T) Key) {
+	t.Helper()
+	for i := 1; i < len(values); i++ {
+		if c := enc(values[i-1]).Compare(enc(values[i])); c >= 0 {
+			t.Errorf("%v: key of %v compares %v to that of %v", name, values[i-1], c, values[i])
+		}
+	}
+}
+
+func TestEncodeOrder(t *testing.T) {
+	ascending(t, "uint", []uint64{0, 1, 255, 256, 1 << 63, math.MaxUint64}, Uintkey)
+	ascending(t, "int", []int64{math.MinInt64, -1 << 40, -256, -1, 0, 1, 255, math.MaxInt64}, Intkey)
+	negnan := math.Float64frombits(0xfff8000000000000)
+	ascending(t, "float64", []float64{negnan, math.Inf(-1), -1e300, -1, -math.SmallestNonzeroFloat64,
+		math.Copysign(0, -1), 0, math.SmallestNonzeroFloat64, 1, 1e300, math.Inf(1), math.NaN()}, Float64key)
+	ascending(t, "time", []time.Time{time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), time.Unix(-2, 5e8),
+		time.Unix(-1, 0), time.Unix(-1, 999999999), time.Unix(0, 0), time.Unix(0, 1), time.Unix(1, 0)}, Timekey)
+	ascending(t, "bool", []bool{false, true}, Boolkey)
+	ascending(t, "bytes", []string{"", "\x00", "\x00\x00", "\x01", "a", "a\x00", "ab", "b"},
+		func(s string) Key { return Stringkey(s, false) })
+	ascending(t, "escaped", []string{"", "\x00", "\x00\x00", "\x00\x01", "\x01", "a", "a\x00", "a\x00b", "a\x01", "ab"},
+		func(s string) Key { return Stringkey(s, true) })
+}
+
+/*
+ * an escaped string stays first when more follows it in the same dimension
+ */
+func TestEncodeEscapedPrefix(t *testing.T) {
+	more := func(s string) Key { return Concat(Stringkey(s, true), Stringkey("\xff\xff", false)) }
+
+	for _, tc := range [][2]string{{"a", "a\x00"}, {"a\x00", "a\x00\x00"}, {"a\x00", "a\x01"}, {"", "\x00"}} {
+		if c := more(tc[0]).Compare(Stringkey(tc[1], true)); c >= 0 {
+			t.Errorf("%q followed by more compares %v to %q", tc[0], c, tc[1])
+		}
+		b, n, err := decodebytes(more(tc[0]), 0, true)
+		if err != nil || string(b) != tc[0] || n != Stringkey(tc[0], true).Bitlen {
+			t.Errorf("decoding %q followed by more = %q, %v, %v", tc[0], b, n, err)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, v := range []int64{math.MinInt64, -1, 0, 1, math.MaxInt64} {
+		if got, err := Keyint(Intkey(v)); err != nil || got != v {
+			t.Errorf("Keyint(Intkey(%v)) = %v, %v", v, got, err)
+		}
+	}
+	for _, v := range []uint64{0, 1, math.MaxUint64} {
+		if got, err := Keyuint(Uintkey(v)); err != nil || got != v {
+			t.Errorf("Keyuint(Uintkey(%v)) = %v, %v", v, got, err)
+		}
+	}
+	for _, v := range []float64{math.Inf(-1), -1.5, math.Copysign(0, -1), 0, 1.5, math.Inf(1), math.NaN()} {
+		if got, err := Keyfloat64(Float64key(v)); err != nil || math.Float64bits(got) != math.Float64bits(v) {
+			t.Errorf("Keyfloat64(Float64key(%v)) = %v, %v", v, got, err)
+		}
+	}
+	for _, v := range []time.Time{time.Date(1900, 1, 1, 0, 0, 0, 7, time.UTC), time.Unix(-1, 999999999), time.Unix(1e9, 5)} {
+		if got, err := Keytime(Timekey(v)); err != nil || !got.Equal(v) {
+			t.Errorf("Keytime(Timekey(%v)) = %v, %v", v, got, err)
+		}
+	}
+	for _, v := range []bool{false, true} {
+		if got, err := Keybool(Boolkey(v)); err != nil || got != v {
+			t.Errorf("Keybool(Boolkey(%v)) = %v, %v", v, got, err)
+		}
+	}
+	for _, s := range []string{"", "a", "\x00", "a\x00\x00b\x01", "\xff\x00\xff"} {
+		for _, escape := range []bool{false, true} {
+			if got, err := Keystring(Stringkey(s, escape), escape); err != nil || got != s {
+				t.Errorf("Keystring(Stringkey(%q, %v)) = %q, %v", s, escape, got, err)
+			}
+		}
+	}
+}
+
+/*
+ * keys not made by the matching encoder fail to decode
+ */
+func TestEncodeInvalid(t *testing.T) {
+	short := Stringkey("abc", false)
+	badnsec := appendbits(appendbits(Key{}, 1<<63, 64), 1e9, timebits-64)
+
+	for name, err := range map[string]error{
+		"int of 24 bits":      func() error { _, err := Keyint(short); return err }(),
+		"float of 1 bit":      func() error { _, err := Keyfloat64(Boolkey(true)); return err }(),
+		"bool of 64 bits":     func() error { _, err := Keybool(Uintkey(1)); return err }(),
+		"time of 64 bits":     func() error { _, err := Keytime(Intkey(0)); return err }(),
+		"a second of nsec":    func() error { _, err := Keytime(badnsec); return err }(),
+		"bytes and a bit":     func() error { _, err := Keybytes(Concat(short, Boolkey(true)), false); return err }(),
+		"escaped with no end": func() error { _, err := Keybytes(short, true); return err }(),
+		"bad escape":          func() error { _, err := Keybytes(Stringkey("a\x00\x02", false), true); return err }(),
+		"escaped and more":    func() error { _, err := Keybytes(Concat(Stringkey("a", true), short), true); return err }(),
+	} {
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("%v: %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+/*
+ * a Retrieve range over encoded ints comes out in numeric order
+ */
+func TestEncodeRetrieve(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	values := []int64{300, -2, math.MaxInt64, 0, -300, math.MinInt64, 2, -1}
+
+	for _, v := range values {
+		if _, err := k.Insert([]Key{Intkey(v)}); err != nil {
+			t.Fatalf("Insert(%v): %v", v, err)
+		}
+	}
+	keys, err := k.Retrieve([]Key{Intkey(-2)}, map[int]int{0: 0})
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	got := []int64{}
+	for _, key := range keys {
+		v, err := Keyint(key[0])
+		if err != nil {
+			t.Fatalf("Keyint(%v): %v", key[0], err)
+		}
+		got = append(got, v)
+	}
+	if want := []int64{-2, -1, 0, 2, 300, math.MaxInt64}; !slices.Equal(got, want) {
+		t.Errorf("Retrieve from -2 = %v, want %v", got, want)
+	}
+}
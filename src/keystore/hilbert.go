@@ -0,0 +1,430 @@
+package keystore
+
+import (
+	"fmt"
+	"iter"
+)
+
+/*
+ * a pacer only picks the dimension the next bit comes from, which is enough for the Z curve but not for the Hilbert curve:
+ * its index takes rotating and reflecting the coordinates bit by bit.
+ * Hilbertkey does that to the coordinates beforehand (J. Skilling, "Programming the Hilbert curve", 2004), giving
+ * the index in transposed form: interleaved a bit at a time, as by Morton, the keys make up the index of the point
+ * along the curve, so keys stored that way sort by it and points near each other along the curve share long prefixes.
+ */
+
+/*
+ * the Hilbert index of the point at axes, in transposed form; axes[d] is the coordinate in dimension d, most
+ * significant bit first, and all coordinates have to be of the same length.
+ */
+func Hilbertkey(axes []Key) ([]Key, error) {
+	x, err := hilbertbits(axes)
+	if err != nil {
+		return nil, err
+	}
+	n, b := len(x), len(x[0])
+
+	for j := 0; j < b-1; j++ { // inverse undo
+		for i := range x {
+			hilbertstep(x, i, j)
+		}
+	}
+	for i := 1; i < n; i++ { // gray encode
+		xor(x[i], x[i-1])
+	}
+	t := make([]uint8, b)
+	for j := 0; j < b-1; j++ {
+		if x[n-1][j] == 1 {
+			flip(t[j+1:])
+		}
+	}
+	for i := range x {
+		xor(x[i], t)
+	}
+	return hilbertkeys(x), nil
+}
+
+/*
+ * the coordinates of the point a Hilbertkey is the index of
+ */
+func Hilbertaxes(key []Key) ([]Key, error) {
+	x, err := hilbertbits(key)
+	if err != nil {
+		return nil, err
+	}
+	n, b := len(x), len(x[0])
+
+	t := make([]uint8, b) // gray decode
+	copy(t[1:], x[n-1])
+	for i := n - 1; i > 0; i-- {
+		xor(x[i], x[i-1])
+	}
+	xor(x[0], t)
+	for j := b - 2; j >= 0; j-- { // undo excess work
+		for i := n - 1; i >= 0; i-- {
+			hilbertstep(x, i, j)
+		}
+	}
+	return hilbertkeys(x), nil
+}
+
+/*
+ * below bit j: invert x[0] if bit j of x[i] is set, else exchange it with x[i]
+ */
+func hilbertstep(x [][]uint8, i, j int) {
+	if x[i][j] == 1 {
+		flip(x[0][j+1:])
+		return
+	}
+	for m := j + 1; m < len(x[0]); m++ {
+		x[0][m], x[i][m] = x[i][m], x[0][m]
+	}
+}
+
+func flip(x []uint8) {
+	for m := range x {
+		x[m] ^= 1
+	}
+}
+
+func xor(x, y []uint8) {
+	for m := range x {
+		x[m] ^= y[m]
+	}
+}
+
+/*
+ * the bits of each of key, which have to be of the same, nonzero length
+ */
+func hilbertbits(key []Key) ([][]uint8, error) {
+	if len(key) == 0 || key[0].Bitlen == 0 {
+		return nil, ErrInvalid
+	}
+	x := make([][]uint8, len(key))
+
+	for d, k := range key {
+		if !k.valid() || k.Bitlen != key[0].Bitlen {
+			return nil, ErrInvalid
+		}
+		x[d] = make([]uint8, k.Bitlen)
+		for j := range x[d] {
+			x[d][j] = k.bit(uint(j))
+		}
+	}
+	return x, nil
+}
+
+func hilbertkeys(x [][]uint8) []Key {
+	key := make([]Key, len(x))
+
+	for d := range x {
+		for _, bit := range x[d] {
+			key[d].append(bit)
+		}
+	}
+	return key
+}
+
+/*
+ * the KeyStore methods of a store paced by "hilbert", over points: keys are the coordinates of a point, of the same
+ * length in each dimension, and are stored as their Hilbertkey; keys handed out are coordinates again.
+ * keys are handed out along the curve; Retrieve ranges go by the dimensions of the index, not by coordinates:
+ * see Cell for the points near one.
+ * the store underneath takes Hilbertkeys alone, and is not to be had but through a Hilbertstore:
+ * New, Create and Open refuse it.
+ */
+type Hilbertstore struct {
+	k *Keystore
+}
+
+/*
+ * a Cursor handing out coordinates
+ */
+type Hilbertcursor struct {
+	*Cursor
+	axes []Key
+}
+
+const hilbertpacer = "hilbert"
+
+/*
+ * ErrMismatch for the pacer of a store whose keys only a Hilbertstore is to make
+ */
+func hilbertonly(pacer string) error {
+	if pacer == hilbertpacer {
+		return fmt.Errorf("%w: paced by %q, to be had as a Hilbertstore", ErrMismatch, pacer)
+	}
+	return nil
+}
+
+/*
+ * Create, of a store paced by "hilbert"; c.Pacer may be left out.
+ */
+func CreateHilbert(c Config) (Hilbertstore, error) {
+	switch c.Pacer {
+	case "":
+		c.Pacer = hilbertpacer
+	case hilbertpacer:
+	default:
+		return Hilbertstore{}, fmt.Errorf("%w: paced by %q, not %q", ErrMismatch, c.Pacer, hilbertpacer)
+	}
+	if c.Dimpace != nil {
+		return Hilbertstore{}, ErrInvalid
+	}
+	k, err := create(c)
+	if err != nil {
+		return Hilbertstore{}, err
+	}
+	return Hilbertstore{k: k}, nil
+}
+
+/*
+ * Open, of a store made by CreateHilbert; ErrMismatch for any other.
+ */
+func OpenHilbert(c Config) (Hilbertstore, error) {
+	if c.Dimpace != nil {
+		return Hilbertstore{}, ErrInvalid
+	}
+	k, err := open(c)
+	switch {
+	case err != nil:
+		return Hilbertstore{}, err
+	case k.Pacer != hilbertpacer:
+		return Hilbertstore{}, fmt.Errorf("%w: paced by %q, not %q", ErrMismatch, k.Pacer, hilbertpacer)
+	}
+	return Hilbertstore{k: k}, nil
+}
+
+/*
+ * the coordinates of keys handed out by the store underneath
+ */
+func hilbertout(keys [][]Key) ([][]Key, error) {
+	for i := range keys {
+		var err error
+		if keys[i], err = Hilbertaxes(keys[i]); err != nil {
+			return nil, ErrCorrupt // not stored by a Hilbertstore
+		}
+	}
+	return keys, nil
+}
+
+func (s Hilbertstore) Insert(axes []Key, shorthands ...int) ([]int, error) {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return nil, err
+	}
+	return s.k.Insert(key, shorthands...)
+}
+
+func (s Hilbertstore) InsertWith(axes []Key, o InsertOptions) ([]int, error) {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return nil, err
+	}
+	return s.k.InsertWith(key, o)
+}
+
+func (s Hilbertstore) Delete(axes []Key, exact ...[]bool) error {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return err
+	}
+	return s.k.Delete(key, exact...)
+}
+
+func (s Hilbertstore) DeleteWith(axes []Key, o DeleteOptions) error {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return err
+	}
+	return s.k.DeleteWith(key, o)
+}
+
+func (s Hilbertstore) Replace(axes, withaxes []Key, exact ...[]bool) error {
+	e, err := exactargs(exact)
+	if err != nil {
+		return err
+	}
+	return s.ReplaceWith(axes, withaxes, ReplaceOptions{Exact: e})
+}
+
+func (s Hilbertstore) ReplaceWith(axes, withaxes []Key, o ReplaceOptions) error {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return err
+	}
+	withkey, err := Hilbertkey(withaxes)
+	if err != nil {
+		return err
+	}
+	return s.k.ReplaceWith(key, withkey, o)
+}
+
+func (s Hilbertstore) Retrieve(axes []Key, more ...interface{}) ([][]Key, error) {
+	o, err := retrieveargs(more)
+	if err != nil {
+		return nil, err
+	}
+	return s.RetrieveWith(axes, o)
+}
+
+func (s Hilbertstore) RetrieveWith(axes []Key, o RetrieveOptions) ([][]Key, error) {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.k.RetrieveWith(key, o)
+	if err != nil {
+		return nil, err
+	}
+	return hilbertout(keys)
+}
+
+func (s Hilbertstore) Put(axes []Key, value []byte) error {
+	return s.PutWith(axes, value, PutOptions{})
+}
+
+func (s Hilbertstore) PutWith(axes []Key, value []byte, o PutOptions) error {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return err
+	}
+	return s.k.PutWith(key, value, o)
+}
+
+func (s Hilbertstore) CompareAndSwap(axes []Key, expect, value []byte) error {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return err
+	}
+	return s.k.CompareAndSwap(key, expect, value)
+}
+
+func (s Hilbertstore) Get(axes []Key) ([]byte, error) {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return nil, err
+	}
+	return s.k.Get(key)
+}
+
+func (s Hilbertstore) RetrieveValues(axes []Key, o RetrieveOptions) ([][]Key, [][]byte, error) {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, values, err := s.k.RetrieveValues(key, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err = hilbertout(keys)
+	return keys, values, err
+}
+
+func (s Hilbertstore) Cursor(axes []Key, more ...interface{}) (*Hilbertcursor, error) {
+	o, err := retrieveargs(more)
+	if err != nil {
+		return nil, err
+	}
+	return s.CursorWith(axes, o)
+}
+
+func (s Hilbertstore) CursorWith(axes []Key, o RetrieveOptions) (*Hilbertcursor, error) {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.k.CursorWith(key, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Hilbertcursor{Cursor: c}, nil
+}
+
+func (c *Hilbertcursor) Next() bool {
+	c.axes = nil
+	if !c.Cursor.Next() {
+		return false
+	}
+	axes, err := Hilbertaxes(c.Cursor.Key())
+	if err != nil {
+		c.err, c.key = ErrCorrupt, nil
+		return false
+	}
+	c.axes = axes
+	return true
+}
+
+func (c *Hilbertcursor) Key() []Key {
+	return c.axes
+}
+
+func (s Hilbertstore) Scan(axes []Key, more ...interface{}) iter.Seq2[[]Key, error] {
+	return hilbertscan(axes, func(key []Key) iter.Seq2[[]Key, error] { return s.k.Scan(key, more...) })
+}
+
+func (s Hilbertstore) ScanWith(axes []Key, o RetrieveOptions) iter.Seq2[[]Key, error] {
+	return hilbertscan(axes, func(key []Key) iter.Seq2[[]Key, error] { return s.k.ScanWith(key, o) })
+}
+
+func (s Hilbertstore) ScanReverse(axes []Key, more ...interface{}) iter.Seq2[[]Key, error] {
+	return hilbertscan(axes, func(key []Key) iter.Seq2[[]Key, error] { return s.k.ScanReverse(key, more...) })
+}
+
+func (s Hilbertstore) ScanReverseWith(axes []Key, o RetrieveOptions) iter.Seq2[[]Key, error] {
+	return hilbertscan(axes, func(key []Key) iter.Seq2[[]Key, error] { return s.k.ScanReverseWith(key, o) })
+}
+
+/*
+ * the scan of the Hilbertkey of axes, in coordinates; a key that is not a Hilbertkey ends it with ErrCorrupt.
+ */
+func hilbertscan(axes []Key, scan func([]Key) iter.Seq2[[]Key, error]) iter.Seq2[[]Key, error] {
+	return func(yield func([]Key, error) bool) {
+		key, err := Hilbertkey(axes)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for key, err := range scan(key) {
+			if err == nil {
+				if key, err = Hilbertaxes(key); err != nil {
+					err = ErrCorrupt
+				}
+			}
+			if !yield(key, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+/*
+ * the points in the cell axes lies in of a grid 2^j cells to a side, along the curve, which runs through it in one go:
+ * their indexes are those that share the first j bits of each dimension with the index of axes.
+ */
+func (s Hilbertstore) Cell(axes []Key, j int) ([][]Key, error) {
+	key, err := Hilbertkey(axes)
+	if err != nil {
+		return nil, err
+	}
+	if j < 0 || j > int(key[0].Bitlen) {
+		return nil, ErrInvalid
+	}
+	matchlen := map[int]int{}
+	for d := range key {
+		first := Key{}
+		for i := uint(0); i < key[d].Bitlen; i++ {
+			if i < uint(j) {
+				first.append(key[d].bit(i))
+			} else {
+				first.append(0)
+			}
+		}
+		key[d], matchlen[d] = first, j
+	}
+	keys, err := s.k.RetrieveWith(key, RetrieveOptions{Matchlen: matchlen})
+	if err != nil {
+		return nil, err
+	}
+	return hilbertout(keys)
+}
@@ -0,0 +1,227 @@
+package keystore
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"bucket_mem"
+)
+
+/*
+ * the point (x, y) of b bit coordinates
+ */
+func point(x, y, b uint) []Key {
+	key := make([]Key, 2)
+
+	for j := b; j > 0; j-- {
+		key[0].append(uint8(x >> (j - 1) & 1))
+		key[1].append(uint8(y >> (j - 1) & 1))
+	}
+	return key
+}
+
+func coords(key []Key) (uint, uint) {
+	return uint(key[0].bits(0, key[0].Bitlen)), uint(key[1].bits(0, key[1].Bitlen))
+}
+
+func adjacent(a, b []Key) bool {
+	ax, ay := coords(a)
+	bx, by := coords(b)
+	return max(ax, bx)-min(ax, bx)+max(ay, by)-min(ay, by) == 1
+}
+
+/*
+ * Hilbertaxes undoes Hilbertkey, and points one after the other along the curve are next to each other
+ */
+func TestHilbertkey(t *testing.T) {
+	const b = 3
+	curve := make([][]Key, 1<<(2*b))
+
+	for x := uint(0); x < 1<<b; x++ {
+		for y := uint(0); y < 1<<b; y++ {
+			key, err := Hilbertkey(point(x, y, b))
+			if err != nil {
+				t.Fatalf("Hilbertkey(%v, %v): %v", x, y, err)
+			}
+			axes, err := Hilbertaxes(key)
+			if err != nil {
+				t.Fatalf("Hilbertaxes: %v", err)
+			}
+			if ax, ay := coords(axes); ax != x || ay != y {
+				t.Errorf("Hilbertaxes(Hilbertkey(%v, %v)) = %v, %v", x, y, ax, ay)
+			}
+			i := 0
+			for j := uint(0); j < b; j++ { // the index, interleaved
+				i = i<<2 | int(key[0].bit(j))<<1 | int(key[1].bit(j))
+			}
+			if curve[i] != nil {
+				t.Fatalf("(%v, %v) has the index of another point", x, y)
+			}
+			curve[i] = point(x, y, b)
+		}
+	}
+	for i := 1; i < len(curve); i++ {
+		if !adjacent(curve[i-1], curve[i]) {
+			t.Errorf("points %v and %v along the curve are not next to each other", i-1, i)
+		}
+	}
+	if _, err := Hilbertkey([]Key{skey("a"), skey("ab")}); err != ErrInvalid {
+		t.Errorf("Hilbertkey of coordinates of different lengths = %v, want ErrInvalid", err)
+	}
+}
+
+/*
+ * a store paced by "hilbert" hands out its points along the curve, also once opened again
+ */
+func TestHilbertstore(t *testing.T) {
+	const b = 3
+	bkt := bucket_mem.New(64)
+	s, err := CreateHilbert(Config{Bucket: bkt, Dims: 2, PacerParams: Pacerparams(2), Values: true})
+	if err != nil {
+		t.Fatalf("CreateHilbert: %v", err)
+	}
+	for x := uint(0); x < 1<<b; x++ {
+		for y := uint(0); y < 1<<b; y++ {
+			if err := s.Put(point(x, y, b), []byte{byte(x), byte(y)}); err != nil {
+				t.Fatalf("Put(%v, %v): %v", x, y, err)
+			}
+		}
+	}
+
+	if s, err = OpenHilbert(Config{Bucket: bkt}); err != nil {
+		t.Fatalf("OpenHilbert: %v", err)
+	}
+	all, err := s.Retrieve(point(0, 0, b), map[int]int{0: 0, 1: 0})
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	if len(all) != 1<<(2*b) {
+		t.Fatalf("%v points, want %v", len(all), 1<<(2*b))
+	}
+	for i := 1; i < len(all); i++ {
+		if !adjacent(all[i-1], all[i]) {
+			t.Errorf("points %v and %v handed out are not next to each other", i-1, i)
+		}
+	}
+	if v, err := s.Get(point(5, 2, b)); err != nil || !slices.Equal(v, []byte{5, 2}) {
+		t.Errorf("Get(5, 2) = %v, %v", v, err)
+	}
+
+	cell, err := s.Cell(point(5, 2, b), 1) // the quarter of the square it is in
+	if err != nil || len(cell) != 16 {
+		t.Fatalf("Cell = %v points, %v; want 16", len(cell), err)
+	}
+	for _, p := range cell {
+		if x, y := coords(p); x < 4 || y >= 4 {
+			t.Errorf("(%v, %v) in the cell of (5, 2)", x, y)
+		}
+	}
+	same := func(a, b []Key) bool { return slices.EqualFunc(a, b, Key.Equal) }
+	if i := slices.IndexFunc(all, func(p []Key) bool { return same(p, cell[0]) }); i < 0 || i+16 > len(all) ||
+		!slices.EqualFunc(cell, all[i:i+16], same) {
+		t.Errorf("the cell of (5, 2) is not a run of the curve")
+	}
+	if _, err := s.Cell(point(5, 2, b), b+1); err != ErrInvalid {
+		t.Errorf("Cell finer than the coordinates = %v, want ErrInvalid", err)
+	}
+}
+
+/*
+ * the other entry points of a Hilbertstore take and hand out coordinates too
+ */
+func TestHilbertstoreEntries(t *testing.T) {
+	const b = 4
+	s, err := CreateHilbert(Config{Bucket: bucket_mem.New(64), Dims: 2, PacerParams: Pacerparams(2), Values: true})
+	if err != nil {
+		t.Fatalf("CreateHilbert: %v", err)
+	}
+	pts := [][2]uint{{1, 2}, {9, 9}, {15, 0}, {3, 14}}
+	for _, p := range pts {
+		if err := s.Put(point(p[0], p[1], b), []byte{byte(p[0])}); err != nil {
+			t.Fatalf("Put(%v): %v", p, err)
+		}
+	}
+	if err := s.Replace(point(9, 9, b), point(8, 8, b), []bool{true, true}); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if err := s.CompareAndSwap(point(8, 8, b), []byte{9}, []byte{8}); err != nil {
+		t.Fatalf("CompareAndSwap: %v", err)
+	}
+	want, err := s.Retrieve(point(0, 0, b), map[int]int{0: 0, 1: 0})
+	if err != nil || len(want) != len(pts) {
+		t.Fatalf("Retrieve = %v points, %v", len(want), err)
+	}
+	if i := slices.IndexFunc(want, func(p []Key) bool { x, y := coords(p); return x == 8 && y == 8 }); i < 0 {
+		t.Errorf("Retrieve after Replace = %v", want)
+	}
+
+	c, err := s.Cursor(point(0, 0, b), map[int]int{0: 0, 1: 0})
+	if err != nil {
+		t.Fatalf("Cursor: %v", err)
+	}
+	for i := 0; c.Next(); i++ {
+		v, err := c.Value()
+		if x, _ := coords(c.Key()); i >= len(want) || !slices.EqualFunc(c.Key(), want[i], Key.Equal) || err != nil || v[0] != byte(x) {
+			t.Errorf("Cursor %v: %v with %v, %v", i, c.Key(), v, err)
+		}
+	}
+	if c.Err() != nil {
+		t.Errorf("Cursor: %v", c.Err())
+	}
+	got := [][]Key{}
+	last, _ := Hilbertaxes(point(15, 15, b)) // the end of the curve
+	for p, err := range s.ScanReverse(last, map[int]int{0: 0, 1: 0}) {
+		if err != nil {
+			t.Fatalf("ScanReverse: %v", err)
+		}
+		got = append(got, p)
+	}
+	slices.Reverse(got)
+	if !slices.EqualFunc(got, want, func(a, b []Key) bool { return slices.EqualFunc(a, b, Key.Equal) }) {
+		t.Errorf("ScanReverse = %v, want %v backwards", got, want)
+	}
+	keys, values, err := s.RetrieveValues(point(8, 8, b), RetrieveOptions{Matchlen: map[int]int{0: b + 1, 1: b + 1}})
+	if err != nil || len(keys) != 1 || !slices.Equal(values[0], []byte{8}) {
+		t.Errorf("RetrieveValues(8, 8) = %v, %v, %v", keys, values, err)
+	}
+}
+
+/*
+ * a store paced by "hilbert" is not to be had but as a Hilbertstore, which is not to be had of another store
+ */
+func TestHilbertonly(t *testing.T) {
+	bkt := bucket_mem.New(64)
+	if _, err := CreateHilbert(Config{Bucket: bkt, Dims: 2, PacerParams: Pacerparams(2)}); err != nil {
+		t.Fatalf("CreateHilbert: %v", err)
+	}
+	for name, err := range map[string]error{
+		"New": func() error {
+			_, err := New(Config{Bucket: bucket_mem.New(64), Dims: 2, Pacer: "hilbert", PacerParams: Pacerparams(2)})
+			return err
+		}(),
+		"Create": func() error {
+			_, err := Create(Config{Bucket: bucket_mem.New(64), Dims: 2, Pacer: "hilbert", PacerParams: Pacerparams(2)})
+			return err
+		}(),
+		"Open": func() error { _, err := Open(Config{Bucket: bkt}); return err }(),
+		"Open with a Dimpace": func() error {
+			_, err := Open(Config{Bucket: bkt, Dimpace: Morton(2)})
+			return err
+		}(),
+	} {
+		if !errors.Is(err, ErrMismatch) {
+			t.Errorf("%v of a store paced by hilbert = %v, want ErrMismatch", name, err)
+		}
+	}
+
+	m := bucket_mem.New(64)
+	if _, err := Create(Config{Bucket: m, Dims: 2, Pacer: "morton", PacerParams: Pacerparams(2)}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := OpenHilbert(Config{Bucket: m}); !errors.Is(err, ErrMismatch) {
+		t.Errorf("OpenHilbert of a store paced by morton = %v, want ErrMismatch", err)
+	}
+	if _, err := CreateHilbert(Config{Bucket: bucket_mem.New(64), Dims: 2, Pacer: "morton", PacerParams: Pacerparams(2)}); !errors.Is(err, ErrMismatch) {
+		t.Errorf("CreateHilbert paced by morton = %v, want ErrMismatch", err)
+	}
+}
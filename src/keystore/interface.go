@@ -1,19 +1,27 @@
 package keystore
 
-import "bucket"
+import (
+	"iter"
+	"bucket"
+)
 
-type Keyelem uint8 // must be unsigned
+type Keyelem uint8 // the element of Key, which the store works on; must be unsigned
 
-const Keyelembits = uint((^Keyelem(0)^(^Keyelem(0)>>1))>>57&64 |
-	(^Keyelem(0)^(^Keyelem(0)>>1))>>26&32 |
-	(^Keyelem(0)^(^Keyelem(0)>>1))>>11&16 |
-	(^Keyelem(0)^(^Keyelem(0)>>1))>>4&8)
+/*
+ * element types keys can be made of: the store takes Key, of Keyelem, and Keystoreof keys of any of these,
+ * converting them a byte at a time. the width of an element goes by its type, see elembits.
+ */
+type Keyelems interface {
+	~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uint
+}
 
-type Key struct {
+type Keyof[E Keyelems] struct {
 	Bitlen uint
-	Bits   []Keyelem
+	Bits   []E
 }
 
+type Key = Keyof[Keyelem]
+
 // @@@ should delete and replace take a shorthand arg?
 
 type KeyStore interface {
@@ -25,7 +33,9 @@ type KeyStore interface {
 	 *      No shorthands are generated if shorthands == 0 or is missing.
 	 * output:
 	 *   uniq[d] = position of first unique bit (with no common prefix) in dimension d.
-	 *      if shorthands are used, this is also the shorthand length for the given dimension.
+	 *      if shorthands are used, this is the shorthand length for the given dimension instead: it takes in the
+	 *        first unique bit, and such bits past it as the shorthand match at the fork calls for.
+	 *      a key that cannot be given an unambiguous shorthand is stored nonetheless, and err is an *AmbiguityError.
 	 *      when a key is a substring of a previously stored key, uniq[n] will be set to the dimension key length
 	 *        (to include the "stop"), e.g.: A 1d store already contains "aa";
 	 *           storing "a" sets uniq[0] to 8, meaning bits 0:7 are common, and (non-existent) bit 8 is unique.
@@ -34,7 +44,9 @@ type KeyStore interface {
 
 	/*
 	 * if exact[d] (optional), delete keys exactly matching key[], or with key[] prefix in dimensions not setting exact[].
-	 * not guaranteed to succeed unless all non-exact keys are paced such that they exhaust simultaneously.
+	 * omitting exact (or exact[d]) means a prefix delete in all dimensions (or in d).
+	 * not atomic unless all non-exact keys are paced such that they exhaust simultaneously:
+	 *   otherwise the keys to delete may be spread over several subtrees, which are deleted one at a time.
 	 */
 	Delete(key []Key, exact ...[]bool) error
 
@@ -43,12 +55,14 @@ type KeyStore interface {
 	 * if exact[d] (optional) is specified, oldkey[d] needs to exactly match the stored key dimension d.
 	 * Regardless of exact, replace is only guaranteed to succeed when, in each dimension d, oldkey[d] is the only
 	 * key matching S, where S is the max length string matching both heads of oldkey[d] and newkey[d].
+	 *   when it does not hold, nothing is written and err is ErrShared (see conditional.go for telling why).
+	 * atomic: a single write replaces the key, also one running on into blocks of its own past S (see replace.go).
 	 */
 	Replace(key []Key, withkey []Key, exact ...[]bool) error
 
 	/*
 	 * Retrieve(key []Key, shorthand bool, matchlen map[int] int, reverse []bool, maxkeys int) ([][]Key, error)
-	 * key is the mandatory. other arguments are optional and can be supplied at any order.
+	 * key is the mandatory. other arguments are optional and can be supplied at any order, each at most once.
 	 * matchlen specifies minimum # of bits to match per dimension
 	 *   entire dimension key is matched if matchlen == nil or matchlen[d] is not set.
 	 *   exact match for the dimension (including stop) is required if matchlen[d] > Key[d].Bitlen.
@@ -56,16 +70,66 @@ type KeyStore interface {
 	 *      (can't have both exact[d] and (matchlen[d] < Key[d].Bitlen))
 	 * reverse[d] specifies backwards search in dimension d.
 	 * shorthand specifies shorthand match.
-	 * maxkeys specifies max number of keys to return; omitting maxkeys means all keys; - use with caution!
+	 * maxkeys specifies max number of keys to return; omitting maxkeys means all keys; - use with caution! (or a Cursor)
 	 * in each dimension d, only keys lexicographically >= (or <= if reverse[d]) key[d] will be returned.
 	 * if shorthand is set, maxkeys is assumed 1; reverse and matchlen should not be supplied.
+	 *   a shorthand matching more than a single key fails with an *AmbiguityError.
 	 */
 	Retrieve(key []Key, moreargs ...interface{}) ([][]Key, error)
+
+	/*
+	 * Cursor(key []Key, shorthand bool, matchlen map[int] int, reverse []bool, maxkeys int, from Token) (*Cursor, error)
+	 * the keys Retrieve would return, one at a time: for c.Next() { c.Key() }, then c.Err().
+	 *   an ambiguous shorthand ends the walk before any key, with c.Err() an *AmbiguityError.
+	 * c.Token() is where c is at; passing it as from resumes past there, so a walk can be taken up again later,
+	 *   e.g. page by page with maxkeys as the page size.
+	 */
+	Cursor(key []Key, moreargs ...interface{}) (*Cursor, error)
+
+	/*
+	 * for key, err := range Scan(key, moreargs...) { ... } goes over what Cursor would;
+	 * ScanReverse goes the other way in each dimension.
+	 */
+	Scan(key []Key, moreargs ...interface{}) iter.Seq2[[]Key, error]
+	ScanReverse(key []Key, moreargs ...interface{}) iter.Seq2[[]Key, error]
+
+	/*
+	 * the above with typed arguments (see options.go), which the variadic ones are adapters for.
+	 * arguments that are out of range, or variadic ones of the wrong type or number, fail with ErrInvalid.
+	 */
+	InsertWith(key []Key, o InsertOptions) (uniq []int, err error)
+	DeleteWith(key []Key, o DeleteOptions) error
+	ReplaceWith(key []Key, withkey []Key, o ReplaceOptions) error
+	RetrieveWith(key []Key, o RetrieveOptions) ([][]Key, error)
+	CursorWith(key []Key, o RetrieveOptions) (*Cursor, error)
+	ScanWith(key []Key, o RetrieveOptions) iter.Seq2[[]Key, error]
+	ScanReverseWith(key []Key, o RetrieveOptions) iter.Seq2[[]Key, error]
+
+	/*
+	 * a store made with Values keeps a value with each key: InsertOptions.Value is stored with a key not there yet,
+	 * Put stores it whether or not, Get looks it up for an exact key and RetrieveValues returns it alongside the keys.
+	 * a Cursor has it as c.Value(). the others take and hand out keys without values, and Replace keeps them.
+	 * stores without Values fail these with ErrNoValues; a key that is not stored, with ErrNotFound.
+	 * PutWith only writes if the key is absent, present, or holds the value expected, as o says;
+	 *   CompareAndSwap is the latter. see conditional.go for these and for the conditional Insert and Replace.
+	 */
+	Put(key []Key, value []byte) error
+	PutWith(key []Key, value []byte, o PutOptions) error
+	CompareAndSwap(key []Key, expect, value []byte) error
+	Get(key []Key) ([]byte, error)
+	RetrieveValues(key []Key, o RetrieveOptions) ([][]Key, [][]byte, error)
 }
 
 /*
  * Given a bit number and a map of dimension key lengths,
  * return the dimension of the bit argument and number of subsequent bits that are in the same dimension.
+ * bits are numbered across all dimensions, not counting stops; the map holds the dimensions that have stopped.
+ * a dimension stops when it is returned after all of its bits were used up.
+ * returning a stopped dimension ends the key, which is only valid if all dimensions are used up by then;
+ * keys that cannot be paced this way are rejected with ErrPace.
+ * e.g. func(b uint, stopmap map[uint]uint) (uint, uint) { return b % 4, 0 } paces 4d keys of equal lengths.
+ * a store outlives its Dimpace: see RegisterPacer for naming one, so the store can be reopened with it.
+ * Checkpace and Simulate try a Dimpace out without a store; Checkkeys tells whether keys can be stored with it.
  */
 type Dimpace func(uint, map[uint]uint) (uint, uint)
 
@@ -73,8 +137,11 @@ type Keystore struct {
 	Dimpace
 	Bucket     bucket.Bucket
 	Root       bucket.Block
-	Bufsize    int // must match that of underlying bucket
+	Bufsize    int // must match that of underlying bucket; see New
 	Compressed bool
+	Dims       int    // # of dimensions of the keys; 0 for any
+	Values     bool   // a value is kept with each key; see values.go
+	Pacer      string // registered name of Dimpace, if it was made by one; see Hilbertof
 	forkfanout uint
 	forkwidth  uint
 }
@@ -0,0 +1,226 @@
+package keystore
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+ * a Key is a string of Bitlen bits, most significant bit of Bits[0] first; whatever Bits holds past Bitlen is ignored,
+ * and keys made here leave it zero.
+ * keys compare the way the store sorts them: bit by bit, and a key before any longer key it is a prefix of.
+ * as text (and JSON), a key is the hex of its bits padded to whole bytes, followed by /Bitlen unless that is
+ * a multiple of 8: "a3" is 10100011, "a0/3" is 101.
+ */
+
+/*
+ * bit i of k, which has to be < k.Bitlen
+ */
+func (k Keyof[E]) Bit(i uint) uint8 {
+	if i >= k.Bitlen {
+		panic(fmt.Sprintf("keystore: bit %v of a %v bit key", i, k.Bitlen))
+	}
+	return k.bit(i)
+}
+
+/*
+ * set bit i of k, which has to be < k.Bitlen, to bit (0 or 1)
+ */
+func (k *Keyof[E]) SetBit(i uint, bit uint8) {
+	if i >= k.Bitlen {
+		panic(fmt.Sprintf("keystore: bit %v of a %v bit key", i, k.Bitlen))
+	}
+	w := elembits[E]()
+	mask := E(1) << (w - 1 - i%w)
+	if bit&1 == 1 {
+		k.Bits[i/w] |= mask
+	} else {
+		k.Bits[i/w] &^= mask
+	}
+}
+
+/*
+ * # of leading bits k and o have in common
+ */
+func (k Keyof[E]) CommonPrefixLen(o Keyof[E]) uint {
+	n, i, w := min(k.Bitlen, o.Bitlen), uint(0), elembits[E]()
+
+	for ; i+w <= n && k.Bits[i/w] == o.Bits[i/w]; i += w {
+	}
+	for ; i < n && k.bit(i) == o.bit(i); i++ {
+	}
+	return i
+}
+
+/*
+ * -1, 0 or 1 as k sorts before, with or after o
+ */
+func (k Keyof[E]) Compare(o Keyof[E]) int {
+	i := k.CommonPrefixLen(o)
+
+	switch {
+	case i == k.Bitlen && i == o.Bitlen:
+		return 0
+	case i == k.Bitlen:
+		return -1 // a stop sorts before bits
+	case i == o.Bitlen:
+		return 1
+	case k.bit(i) < o.bit(i):
+		return -1
+	}
+	return 1
+}
+
+func (k Keyof[E]) Equal(o Keyof[E]) bool {
+	return k.Bitlen == o.Bitlen && k.CommonPrefixLen(o) == k.Bitlen
+}
+
+/*
+ * a copy of k with bits (0 or 1 each) appended
+ */
+func (k Keyof[E]) Append(bits ...uint8) Keyof[E] {
+	c := k.clone()
+
+	for _, b := range bits {
+		c.append(b & 1)
+	}
+	return c
+}
+
+/*
+ * the bits of keys, one after another
+ */
+func Concat[E Keyelems](keys ...Keyof[E]) Keyof[E] {
+	c := Keyof[E]{}
+
+	for _, k := range keys {
+		for i := uint(0); i < k.Bitlen; i++ {
+			c.append(k.bit(i))
+		}
+	}
+	return c
+}
+
+/*
+ * the 8*len(b) bits of b
+ */
+func FromBytes(b []byte) Key {
+	return frombytes[Keyelem](b)
+}
+
+func frombytes[E Keyelems](b []byte) Keyof[E] {
+	k := Keyof[E]{}
+
+	for _, c := range b {
+		k = appendbits(k, uint64(c), 8)
+	}
+	return k
+}
+
+/*
+ * the bits of k, padded with zeros to whole bytes
+ */
+func (k Keyof[E]) Bytes() []byte {
+	b := make([]byte, (k.Bitlen+7)/8)
+
+	for i := range b {
+		off := uint(i) * 8
+		n := min(8, k.Bitlen-off)
+		b[i] = byte(k.bits(off, n) << (8 - n))
+	}
+	return b
+}
+
+/*
+ * the bits of k as 0s and 1s; %x and %X format k in hex, as MarshalText does.
+ */
+func (k Keyof[E]) String() string {
+	var sb strings.Builder
+
+	for i := uint(0); i < k.Bitlen; i++ {
+		sb.WriteByte('0' + k.bit(i))
+	}
+	return sb.String()
+}
+
+func (k Keyof[E]) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'x':
+		f.Write(k.hex())
+	case 'X':
+		f.Write([]byte(strings.ToUpper(string(k.hex()))))
+	case 'b', 's', 'v':
+		f.Write([]byte(k.String()))
+	default:
+		fmt.Fprintf(f, "%%!%c(keystore.Key=%v)", verb, k.String())
+	}
+}
+
+func (k Keyof[E]) hex() []byte {
+	t := hex.AppendEncode(nil, k.Bytes())
+	if k.Bitlen%8 != 0 {
+		t = strconv.AppendUint(append(t, '/'), uint64(k.Bitlen), 10)
+	}
+	return t
+}
+
+func (k Keyof[E]) MarshalText() ([]byte, error) {
+	return k.hex(), nil
+}
+
+func (k *Keyof[E]) UnmarshalText(t []byte) error {
+	h, bitlen, cut := strings.Cut(string(t), "/")
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return fmt.Errorf("%w: key %q: %v", ErrInvalid, t, err)
+	}
+	n := uint64(len(b)) * 8
+	if cut {
+		if n, err = strconv.ParseUint(bitlen, 10, 0); err != nil || n > uint64(len(b))*8 || n+8 <= uint64(len(b))*8 {
+			return fmt.Errorf("%w: key %q: bad length", ErrInvalid, t)
+		}
+	}
+	*k = frombytes[E](b).Substr(0, uint(n))
+	return nil
+}
+
+/*
+ * uvarint Bitlen, then Bytes
+ */
+func (k Keyof[E]) MarshalBinary() ([]byte, error) {
+	return append(binary.AppendUvarint(nil, uint64(k.Bitlen)), k.Bytes()...), nil
+}
+
+func (k *Keyof[E]) UnmarshalBinary(data []byte) error {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || (n+7)/8 != uint64(len(data)-l) {
+		return fmt.Errorf("%w: key of %v bytes", ErrInvalid, len(data))
+	}
+	*k = frombytes[E](data[l:]).Substr(0, uint(n))
+	return nil
+}
+
+/*
+ * a copy of k, of as many elements as Bitlen takes, with the bits past Bitlen cleared
+ */
+func (k Keyof[E]) clone() Keyof[E] {
+	w := elembits[E]()
+	c := Keyof[E]{Bitlen: k.Bitlen, Bits: make([]E, (k.Bitlen+w-1)/w)}
+
+	copy(c.Bits, k.Bits)
+	c.trim()
+	return c
+}
+
+/*
+ * clear the bits of the last element past Bitlen
+ */
+func (k *Keyof[E]) trim() {
+	w := elembits[E]()
+	if r := k.Bitlen % w; r != 0 {
+		k.Bits[k.Bitlen/w] &= ^E(0) << (w - r)
+	}
+}
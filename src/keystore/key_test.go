@@ -0,0 +1,135 @@
+package keystore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+/*
+ * the key of the bits in s, a string of 0s and 1s
+ */
+func bitkey[E Keyelems](s string) Keyof[E] {
+	k := Keyof[E]{}
+
+	for _, c := range s {
+		k.append(uint8(c - '0'))
+	}
+	return k
+}
+
+/*
+ * the toolkit on keys of elements E, with bits crossing element boundaries
+ */
+func keytoolkit[E Keyelems](t *testing.T) {
+	w := elembits[E]()
+	name := fmt.Sprintf("%v bit elements", w)
+	long := bitkey[E]("1011" + fmt.Sprintf("%0*b", w, 1) + "0110")
+
+	for i, c := range long.String() {
+		if long.Bit(uint(i)) != uint8(c-'0') {
+			t.Errorf("%v: Bit(%v) of %v = %v", name, i, long, long.Bit(uint(i)))
+		}
+	}
+	if s := long.Substr(w, 8).String(); s != long.String()[w:w+8] {
+		t.Errorf("%v: Substr(%v, 8) of %v = %v", name, w, long, s)
+	}
+	if s := long.Substr(long.Bitlen-3, 8).String(); s != "110" {
+		t.Errorf("%v: Substr past the end of %v = %v, want 110", name, long, s)
+	}
+
+	set := long.clone()
+	set.SetBit(w+2, 1)
+	set.SetBit(0, 0)
+	if set.Bit(w+2) != 1 || set.Bit(0) != 0 || long.Bit(0) != 1 || set.CommonPrefixLen(long) != 0 {
+		t.Errorf("%v: SetBit on a clone of %v = %v", name, long, set)
+	}
+	if n := bitkey[E]("1011" + fmt.Sprintf("%0*b", w, 3)).CommonPrefixLen(long); n != w+2 {
+		t.Errorf("%v: CommonPrefixLen = %v, want %v", name, n, w+2)
+	}
+
+	dirty := Keyof[E]{Bitlen: 3, Bits: []E{^E(0)}}
+	if clean := bitkey[E]("111"); !dirty.Equal(clean) || dirty.Compare(clean) != 0 {
+		t.Errorf("%v: %v with bits set past Bitlen does not equal %v", name, dirty, clean)
+	}
+	if a := dirty.Append(0); a.Bits[0] != E(0xe)<<(w-4) {
+		t.Errorf("%v: Append(0) to %v left %x", name, dirty, a.Bits)
+	}
+
+	order := []string{"", "0", "00", "01", "0111", "1", "10", "11"}
+	for i := range order {
+		for j := range order {
+			a, b := bitkey[E](order[i]), bitkey[E](order[j])
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if c := a.Compare(b); c != want {
+				t.Errorf("%v: Compare(%q, %q) = %v, want %v", name, order[i], order[j], c, want)
+			}
+		}
+	}
+
+	if c := Concat(bitkey[E]("101"), Keyof[E]{}, long); c.String() != "101"+long.String() {
+		t.Errorf("%v: Concat = %v", name, c)
+	}
+	if b := frombytes[E]([]byte{0xa5, 0x0f}); b.Bitlen != 16 || b.String() != "1010010100001111" {
+		t.Errorf("%v: frombytes = %v", name, b)
+	}
+	if b := bitkey[E]("1010010111").Bytes(); string(b) != "\xa5\xc0" {
+		t.Errorf("%v: Bytes = %x, want a5c0", name, b)
+	}
+}
+
+func TestKeyToolkit(t *testing.T) {
+	keytoolkit[uint8](t)
+	keytoolkit[uint16](t)
+	keytoolkit[uint32](t)
+	keytoolkit[uint64](t)
+}
+
+func TestKeyFormat(t *testing.T) {
+	for _, tc := range []struct {
+		bits, hex string
+	}{
+		{"", ""},
+		{"101", "a0/3"},
+		{"10100011", "a3"},
+		{"1010001111", "a3c0/10"},
+	} {
+		k := bitkey[Keyelem](tc.bits)
+		if s := fmt.Sprintf("%v %x %X", k, k, k); s != tc.bits+" "+tc.hex+" "+strings.ToUpper(tc.hex) {
+			t.Errorf("formatting %v = %q", tc.bits, s)
+		}
+		text, _ := k.MarshalText()
+		back := Key{}
+		if string(text) != tc.hex || back.UnmarshalText(text) != nil || !back.Equal(k) {
+			t.Errorf("MarshalText(%v) = %q, back %v", tc.bits, text, back)
+		}
+		bin, _ := k.MarshalBinary()
+		back = Key{}
+		if back.UnmarshalBinary(bin) != nil || !back.Equal(k) {
+			t.Errorf("MarshalBinary(%v) = %x, back %v", tc.bits, bin, back)
+		}
+		j, err := json.Marshal([]Key{k})
+		wide := []Keyof[uint32]{}
+		if err != nil || string(j) != `["`+tc.hex+`"]` || json.Unmarshal(j, &wide) != nil || wide[0].String() != tc.bits {
+			t.Errorf("JSON of %v = %s, %v; back %v", tc.bits, j, err, wide)
+		}
+	}
+	for _, bad := range []string{"zz", "a0/9", "a0/0", "a0/x", "a0a0/3"} {
+		if err := new(Key).UnmarshalText([]byte(bad)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("UnmarshalText(%q) = %v, want ErrInvalid", bad, err)
+		}
+	}
+	for _, bad := range [][]byte{{}, {3}, {8, 1, 2}} {
+		if err := new(Key).UnmarshalBinary(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("UnmarshalBinary(%x) = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
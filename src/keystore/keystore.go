@@ -1,97 +1,126 @@
 package keystore
 
 import (
+	"errors"
 	"io"
 	"bucket"
 )
 
-func (k Keystore) Insert(key []Key, more ...int) ([]int, error) { // @@@ watch for forkfanout/forkwidth
-	uniq := make([]int, len(key))
-	shorthands := 0
-	//	stopped := make([]bool, len(key))
-
-	if len(more) == 1 {
-		shorthands = more[0]
-	} else if len(more) > 1 {
-		panic("Keystore Insert: too many args")
+func (k Keystore) Insert(key []Key, more ...int) ([]int, error) {
+	o, err := insertargs(more)
+	if err != nil {
+		return nil, err
 	}
+	return k.InsertWith(key, o)
+}
 
-	/*
-	 * must make sure that all dimension keys supplied exhaust simultaneously.
-	 */
-	if shorthands > 1 { // get rid of this in real code
-		shorthands = 2
+func (k Keystore) InsertWith(key []Key, o InsertOptions) ([]int, error) { // @@@ watch for forkfanout/forkwidth
+	if err := k.checkkey(key); err != nil {
+		return nil, err
+	}
+	if o.Shorthands < 0 {
+		return nil, ErrInvalid
+	}
+	if k.Values || o.Value != nil {
+		return k.insertvalue(key, o)
 	}
-	return uniq, nil
+	uniq, err := k.insertkey(key, o.Shorthands)
+	if err == nil && o.IfAbsent && uniq[0] > int(key[0].Bitlen) {
+		return nil, ErrExists
+	}
+	return uniq, err
 }
 
 /*
- * Delete discards blocks at tail end of the deleted string trimming keys and restarting downtree search until it hits block that keeps the last fork.
- * within that block, it deletes one branch.
+ * the dimensions are interleaved by Dimpace; a key it cannot pace fails with ErrPace before anything is written.
+ * retry as long as we lose races on the block we write.
  */
-func (k Keystore) Delete(key []Key, more ...[]bool) error {
-	exact := []bool(nil)
-
-	if len(more) == 1 {
-		exact = more[0]
-	} else if len(more) > 1 {
-		panic("Keystore Delete: too many args")
-	}
-
-	if exact[0] { // get rid of this in real code
-		return nil
+func (k Keystore) insertkey(key []Key, shorthands int) ([]int, error) {
+	for {
+		uniq, err := k.insert(key, shorthands)
+		if _, lost := err.(bucket.Link); !lost {
+			return uniq, err
+		}
 	}
-	return nil
 }
 
+var ErrDims = errors.New("multiple dimensions need a Dimpace")
+
 /*
- * Replace oldkey with newkey.
- * if exact[d] is specified, oldkey[d] needs to exactly match the stored key dimension d.
- * Regardless of exact, replace is only guaranteed to succeed when, in each dimension d, oldkey[d] is the only
- * key matching S, where S is the max length string matching both heads of oldkey[d] and newkey[d].
+ * a single attempt: find where key diverges from the stored keys, branch off there and write the block back.
+ * with shorthands, check the shorthand once written.
  */
-func (k Keystore) Replace(oldkey []Key, newkey []Key, more ...[]bool) error {
-	exact := []bool(nil)
+func (k Keystore) insert(key []Key, shorthands int) ([]int, error) {
+	state := searchstate{k: &k}
+	p := state.downtree_prep(key)
 
-	if len(more) == 1 {
-		exact = more[0]
-	} else if len(more) > 1 {
-		panic("Keystore Replace: too many args")
+	b, err := state.fetch(remote{bn: k.Root}, p.snapshot())
+	if err != nil {
+		return nil, err
 	}
+	if b, err = state.downtree(b, p); err != nil {
+		return nil, err
+	}
+	defer k.Bucket.Release((*bucket.Buf)(b.buf))
 
-	if exact[0] { // get rid of this in real code
-		return nil
+	uniq := p.uniq()
+	if len(b.seg) > 0 && (len(state.bitpath) == 0 && len(state.forkpath) == 0 || k.Values && valued(p)) { // already there
+		for d := range uniq {
+			uniq[d] = int(key[d].Bitlen) + 1
+		}
+		return uniq, nil
 	}
-	return nil
+	tail, err := p.clone().rest()
+	if err != nil {
+		return nil, err
+	}
+	need, stops := uint(0), uint(len(p.stopmap))
+	if shorthands > 0 && uint(shorthands) > p.bitnum {
+		need = uint(shorthands) - p.bitnum
+	}
+	var ext, sm uint
+	var ok bool
+	switch {
+	case len(b.seg) == 0: // empty store
+		ext, _, ok = shorthandext(tail, stops, nil, need, true)
+		b.seg = []segment{{syms: tail}}
+	case len(state.bitpath) > 0:
+		oldsm := state.carry % 16
+		ext, sm, ok = shorthandext(tail, stops, []uint{oldsm}, need, false)
+		b.branch(state.segpath[len(state.segpath)-1].segidx, state.bitpath[0].bitnum, tail, oldsm, sm)
+	default:
+		seg := &b.seg[state.segpath[len(state.segpath)-1].segidx]
+		sib := []uint{}
+		for _, e := range seg.f.fe {
+			sib = append(sib, e.shorthandmatch)
+		}
+		ext, sm, ok = shorthandext(tail, stops, sib, need, false)
+		b.addbranch(state.segpath[len(state.segpath)-1].segidx, tail, sm)
+	}
+	if err = state.commit(b); err != nil || shorthands <= 0 {
+		return uniq, err
+	}
+	p.skip(tail, ext)
+	if uniq = p.uniq(); !ok {
+		return uniq, &AmbiguityError{Keys: [][]Key{key}}
+	}
+	return uniq, k.resolves(key, uniq)
 }
 
 func (k Keystore) Retrieve(key []Key, more ...interface{}) ([][]Key, error) {
-	shorthand := false
-	matchlen := map[int]int(nil)
-	reverse := []bool(nil)
-	maxkeys := 1
-
-	for i := range more {
-		switch v := more[i].(type) {
-		case bool:
-			shorthand = v
-		case map[int]int:
-			matchlen = v
-		case []bool:
-			reverse = v
-		case int:
-			maxkeys = v
-		default:
-			i = 99
-		}
-		if i > 2 || (shorthand && i > 0) {
-			panic("Keystore retrieve: invalid args")
-		}
+	o, err := retrieveargs(more)
+	if err != nil {
+		return nil, err
 	}
+	return k.RetrieveWith(key, o)
+}
 
-	curkey := make([]Key, len(key))
+func (k Keystore) RetrieveWith(key []Key, o RetrieveOptions) ([][]Key, error) {
+	c, err := k.CursorWith(key, o)
+	if err != nil {
+		return nil, err
+	}
 
-	ret := make([][]Key, maxkeys)
 	/*
 	 * if shorthand, follow each dimension to full key length and then follow shorthand path;
 	 *    at each fork, walk the branch with shorthand match bits equal to total unmatched bits, (if such exists)
@@ -106,13 +135,12 @@ func (k Keystore) Retrieve(key []Key, more ...interface{}) ([][]Key, error) {
 	 *	    (until reaching a string on the wrong side of the complete array or a fork with all entries on the wrong side).
 	 * never collect more than maxkeys.
 	 */
-	//	for i:= range ret {
-	//		ret[i] = make([]Key, k.dim) // actually should dynamically append per each []Key returned
-	//	}
-	ret = append(ret, curkey) // ... like so
-
-	if reverse[0] && shorthand { // get rid of this in real code
-		matchlen[0] = maxkeys
+	ret := [][]Key(nil)
+	for c.Next() {
+		ret = append(ret, c.Key())
+	}
+	if c.Err() != nil {
+		return nil, c.Err()
 	}
 	return ret, nil
 }
@@ -128,14 +156,9 @@ type segcomp struct {
 	segidx int
 }
 
-type strcomp struct {
-	keybit []int // per dimension bit num; Bitlen+1 if seen stop
-	strnum int   // string number in segment
-}
-
 type bitcomp struct {
 	keybit []int // per dimension bit num; Bitlen+1 if seen stop
-	bitnum int   // in last string, bit num where above bits were consumed
+	bitnum int   // in last segment, symbol num where above bits were consumed
 }
 
 type forkcomp struct {
@@ -149,7 +172,6 @@ type forkcomp struct {
 
 type rempath []remcomp
 type segpath []segcomp
-type strpath []strcomp
 type bitpath []bitcomp   // could have 0 or 1 elements
 type forkpath []forkcomp // could have 0 or 1 elements; can't have both bitpath and forkpath
 
@@ -157,61 +179,190 @@ type searchstate struct {
 	k        *Keystore
 	rempath        // blocks
 	segpath        // segments in _last_block
-	strpath        // strings in _last_ segment
-	bitpath        // bit in last string
+	bitpath        // symbol in last segment
 	forkpath       // ... or in last fork
 	forks    []int // segment numbers of forks seen during search (in last block)
+	carry    uint  // shorthandmatch of the branch taken at the last fork, plus the symbols since
 }
 
-func (state *searchstate) downtree_prep(key []Key) (startbit uint, stopmap map[uint]uint) {
-	var keybit []int
-
-	startbit = 0
+func (state *searchstate) keybit() []int {
 	switch {
 	case len(state.bitpath) > 0:
-		keybit = state.bitpath[0].keybit
+		return state.bitpath[0].keybit
 	case len(state.forkpath) > 0:
-		keybit = state.forkpath[0].keybit
-	case len(state.strpath) > 0:
-		keybit = state.strpath[len(state.strpath)-1].keybit
+		return state.forkpath[0].keybit
 	case len(state.segpath) > 0:
-		keybit = state.segpath[len(state.segpath)-1].keybit
+		return state.segpath[len(state.segpath)-1].keybit
 	case len(state.rempath) > 0:
-		keybit = state.rempath[len(state.rempath)-1].keybit
-	default:
-		keybit = make([]int, 0)
+		return state.rempath[len(state.rempath)-1].keybit
 	}
-	for d, k := range keybit {
+	return nil
+}
+
+/*
+ * a pacer for key, picking up where state left off.
+ */
+func (state *searchstate) downtree_prep(key []Key) *pacer {
+	p := &pacer{key: key, dim: state.k.Dimpace, keybit: make([]int, len(key)), stopmap: make(map[uint]uint), values: state.k.Values}
+
+	copy(p.keybit, state.keybit())
+	for d, k := range p.keybit {
 		if key[d].Bitlen < uint(k) {
 			k--
-			stopmap[uint(d)] = uint(k)
+			p.stopmap[uint(d)] = uint(k)
 		}
-		startbit += uint(k)
+		p.bitnum += uint(k)
 	}
-	return
-	// can later call state.downtree(block, key, matchstop, dim, startbit, stopmap)
+	return p
+	// can later call state.downtree(block, p)
+}
+
+/*
+ * fetch and parse the block r points at, with a link, and push it on rempath.
+ * fails with the parent's link if the parent was modified meanwhile, as r might no longer be what it points at,
+ * and with its own if it is an empty block below the root.
+ */
+func (state *searchstate) fetch(r remote, keybit []int) (*block, error) {
+	bkt := state.k.Bucket
+	lost := func() error {
+		if n := len(state.rempath); n > 0 && modified(bkt, state.rempath[n-1].rem.bn, state.rempath[n-1].link) {
+			return state.rempath[n-1].link
+		}
+		return nil
+	}
+
+	buf, link, err := bkt.Fetch(r.bn, true)
+	if err != nil {
+		if l := lost(); l != nil {
+			return nil, l
+		}
+		return nil, err
+	}
+	if err = lost(); err != nil {
+		bkt.Release(buf)
+		return nil, err
+	}
+	b, err := state.k.parse(r.bn, buf, link)
+	if err != nil {
+		bkt.Release(buf)
+		return nil, err
+	}
+	if len(b.seg) == 0 && len(state.rempath) > 0 { // sealed by a Delete cutting it off; see delete.go
+		bkt.Release(buf)
+		return nil, link
+	}
+	b.address, b.buf = r.bn, (*buff)(buf)
+	state.rempath = append(state.rempath, remcomp{keybit: keybit, rem: r, link: link})
+	return b, nil
+}
+
+/*
+ * fetch for walks that only need the parsed block; the link stays on rempath for checking children against.
+ */
+func (state *searchstate) load(r remote) (*block, error) {
+	b, err := state.fetch(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	state.k.Bucket.Release((*bucket.Buf)(b.buf))
+	return b, nil
 }
 
 /*
  * downtree search:
  * follow all dimensions from a given searchstate to ambiguity or exhastion. update searchstate.
  * on return, hold a referenced copy of the block where search was terminated, but not of parent blocks.
- * matchstop[d] specifies whether a stop should be matched at dimension d.
  * return in one of the following conditions:
- *   - we have matched all dimensions where stop was to be matched and at least one other dimension key is exhausted (key exhaustion)
- *   - the next key bit to be matched does not match the next one in the keystore (keystore exhastion)
- *   - we are at a fork and do not have sufficient key bits to match a unique branch (ambiguity) -- in which case state.forkmap
+ *   - the key is exhausted (all dimensions stopped): it is stored, and segpath ends at its last segment
+ *   - the next key bit to be matched does not match the next one in the keystore (keystore exhastion):
+ *     bitpath or forkpath tell where.
+ *   - the block is empty: so is the store.
+ * @@@ we are at a fork and do not have sufficient key bits to match a unique branch (ambiguity) -- in which case state.forkmap
  *     reflects how far the fork can be matched. caller would figure out what to do further.
  */
-func (state *searchstate) downtree(b block, key []Key, matchstop []bool, dim Dimpace, startbit uint, stopmap map[uint]uint) *block {
-	// startbit and stopmap are eligible args for Dimpace
-	return &block{}
+func (state *searchstate) downtree(b *block, p *pacer) (*block, error) {
+	bkt := state.k.Bucket
+	fail := func(err error) (*block, error) {
+		bkt.Release((*bucket.Buf)(b.buf))
+		return nil, err
+	}
+
+	if len(b.seg) == 0 {
+		return b, nil
+	}
+	state.segpath = append(state.segpath[:0], segcomp{keybit: p.snapshot(), segidx: 0})
+	for {
+		seg := &b.seg[state.segpath[len(state.segpath)-1].segidx]
+
+		for off, s := range seg.syms {
+			if p.done() {
+				return fail(ErrCorrupt) // a stored key extends this one
+			}
+			d, sym, err := p.peek()
+			if err != nil {
+				return fail(err)
+			}
+			if sym != s {
+				state.bitpath = append(state.bitpath[:0], bitcomp{keybit: p.snapshot(), bitnum: off})
+				return b, nil
+			}
+			p.take(d, sym)
+			state.carry++
+		}
+
+		switch {
+		case seg.has_remote:
+			nb, err := state.fetch(seg.r, p.snapshot())
+			if err != nil {
+				return fail(err)
+			}
+			bkt.Release((*bucket.Buf)(b.buf))
+			b = nb
+			state.segpath = append(state.segpath[:0], segcomp{keybit: p.snapshot(), segidx: 0})
+			state.forks = state.forks[:0]
+		case p.done():
+			if seg.has_fork {
+				return fail(ErrCorrupt)
+			}
+			return b, nil
+		case seg.has_fork:
+			_, sym, err := p.peek()
+			if err != nil {
+				return fail(err)
+			}
+			i := 0
+			for ; i < len(seg.f.fe) && symorder(b.seg[seg.f.fe[i].segidx].syms[0]) < symorder(sym); i++ {
+			}
+			state.forks = append(state.forks, state.segpath[len(state.segpath)-1].segidx)
+			if i == len(seg.f.fe) || b.seg[seg.f.fe[i].segidx].syms[0] != sym {
+				state.forkpath = append(state.forkpath[:0], forkcomp{keybit: p.snapshot(), first: i, n: 0})
+				return b, nil
+			}
+			state.segpath = append(state.segpath, segcomp{keybit: p.snapshot(), segidx: int(seg.f.fe[i].segidx)})
+			state.carry = seg.f.fe[i].shorthandmatch
+		default:
+			return fail(ErrCorrupt) // this one extends a stored key
+		}
+	}
 }
 
 func modified(bkt bucket.Bucket, bn bucket.Block, link bucket.Link) bool {
 	return bkt.Replace(bn, &(bucket.Buf{}), 0, link, false) != nil
 }
 
+/*
+ * parse block bn, fetched with link into buf, which may be the block itself, rewritten as it is read (see bucket.Fetch):
+ * the parse holds only if the link still does once it is done, otherwise it fails with the link, to fetch bn again.
+ * the parsed block is a copy, and does not change with buf.
+ */
+func (k Keystore) parse(bn bucket.Block, buf *bucket.Buf, link bucket.Link) (*block, error) {
+	b, err := demarshall((*buff)(buf), k.Compressed)
+	if modified(k.Bucket, bn, link) {
+		return nil, link
+	}
+	return b, err
+}
+
 func (buf *buff) parseblock(compressed bool) *block {
 	b, err := demarshall(buf, compressed)
 	if err != nil {
@@ -271,7 +422,6 @@ scan:
 			}
 			state.rempath = state.rempath[:i+1]
 			state.segpath = state.segpath[:0]
-			state.strpath = state.strpath[:0]
 			state.bitpath = state.bitpath[:0]
 			state.forkpath = state.forkpath[:0]
 			state.forks = state.forks[:0]
@@ -285,8 +435,22 @@ scan:
 
 /*
  * ready to write a subtree:
- * takes path trail to the parent, and block buffer and address at the top of the new subtree.
+ * takes path trail to the parent, and the (modified) parsed block at the top of the new subtree.
+ * the block is packed, spilling over to newly kept blocks as needed.
  * upon success, parent block will be written in place.
  *     it is the caller's responsibility to discard blocks from the old subtree.
- * upon failure, it is the caller's responsibility to free resources before restarting.
+ * upon failure, newly kept blocks are discarded; a Link error means the caller should restart.
  */
+func (state *searchstate) commit(b *block) error {
+	k := state.k
+	buf := buff(make([]byte, k.Bufsize))
+
+	kept, err := k.pack(b, &buf)
+	if err == nil {
+		err = k.Bucket.Replace(b.address, (*bucket.Buf)(&buf), 0, state.rempath[len(state.rempath)-1].link, false)
+	}
+	if err != nil && len(kept) > 0 {
+		k.Bucket.Discard(kept...)
+	}
+	return err
+}
@@ -0,0 +1,277 @@
+package keystore
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"bucket"
+	"bucket_mem"
+)
+
+/*
+ * a bucket that keeps track of the blocks kept and not discarded, to tell spilling and reclamation by
+ */
+type counting struct {
+	bucket.Bucket
+	mu   sync.Mutex
+	live map[bucket.Block]bool
+}
+
+func (c *counting) Keep(b *bucket.Buf, decref bool) (bucket.Block, bucket.Gen, error) {
+	bn, gen, err := c.Bucket.Keep(b, decref)
+	if err == nil {
+		c.mu.Lock()
+		c.live[bn] = true
+		c.mu.Unlock()
+	}
+	return bn, gen, err
+}
+
+func (c *counting) Discard(d ...bucket.Block) error {
+	c.mu.Lock()
+	for _, bn := range d {
+		delete(c.live, bn)
+	}
+	c.mu.Unlock()
+	return c.Bucket.Discard(d...)
+}
+
+func (c *counting) blocks() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.live)
+}
+
+func newcounting(bufsize int) *counting {
+	return &counting{Bucket: bucket_mem.New(bufsize), live: make(map[bucket.Block]bool)}
+}
+
+/*
+ * a store on a counting bucket, as c says
+ */
+func newstore(t *testing.T, bufsize int, c Config) (*Keystore, *counting) {
+	t.Helper()
+	bkt := newcounting(bufsize)
+	c.Bucket = bkt
+	k, err := New(c)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return k, bkt
+}
+
+/*
+ * the 8*len(s) bits of s
+ */
+func skey(s string) Key {
+	return Key{Bitlen: uint(8 * len(s)), Bits: []Keyelem(s)}
+}
+
+func skeys(s ...string) []Key {
+	key := make([]Key, len(s))
+
+	for d := range s {
+		key[d] = skey(s[d])
+	}
+	return key
+}
+
+/*
+ * keys as strings, one "d0/d1/..." per key, for comparing results by
+ */
+func kstrings(keys [][]Key) []string {
+	ss := []string{}
+
+	for _, key := range keys {
+		s := ""
+		for d, k := range key {
+			if d > 0 {
+				s += "/"
+			}
+			s += string(k.Bytes())
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+func insert(t *testing.T, k *Keystore, key ...string) {
+	t.Helper()
+	for _, s := range key {
+		if _, err := k.Insert(skeys(s)); err != nil {
+			t.Fatalf("Insert(%q): %v", s, err)
+		}
+	}
+}
+
+func retrieveall(t *testing.T, k *Keystore, dims int) []string {
+	t.Helper()
+	keys, err := k.Retrieve(make([]Key, dims), map[int]int{})
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	return kstrings(keys)
+}
+
+/*
+ * enough keys to fill several small blocks come back in order
+ */
+func TestInsertSpills(t *testing.T) {
+	k, bkt := newstore(t, 64, Config{})
+	want := []string{}
+
+	for i := 0; i < 300; i++ {
+		want = append(want, fmt.Sprintf("key%04d", i))
+	}
+	for i := range want {
+		insert(t, k, want[i*7%len(want)])
+	}
+	if n := bkt.blocks(); n < 10 {
+		t.Errorf("%v keys of %v bytes in %v blocks of 64 bytes", len(want), len(want[0]), n)
+	}
+	if got := retrieveall(t, k, 1); !slices.Equal(got, want) {
+		t.Errorf("Retrieve returned %v keys, want %v:\n%q", len(got), len(want), got)
+	}
+	for _, s := range want {
+		keys, err := k.Retrieve(skeys(s), map[int]int{0: len(s)*8 + 1})
+		if err != nil || len(keys) != 1 || !keys[0][0].Equal(skey(s)) {
+			t.Errorf("exact Retrieve(%q) = %q, %v", s, kstrings(keys), err)
+		}
+	}
+}
+
+/*
+ * spill never moves a stub, even one keeping a stop, which it would only swap for the same again
+ */
+func TestSpillStub(t *testing.T) {
+	b := &block{seg: []segment{
+		{syms: []uint8{0}, has_fork: true, f: fork{fe: []forkelem{{segidx: 1}, {segidx: 2}}}},
+		{syms: []uint8{symstop}, has_remote: true, r: remote{bn: 1}},
+		{syms: []uint8{1}, has_remote: true, r: remote{bn: 2}},
+	}}
+
+	if nb, _ := b.spill(32); nb != nil {
+		t.Errorf("spill moved %+v out of a block of stubs", nb.seg)
+	}
+}
+
+/*
+ * uniq is the first bit a key does not share with a stored one; for a prefix of a stored key, that is its stop
+ */
+func TestUniq(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+
+	for _, tc := range []struct {
+		key  string
+		uniq int
+	}{
+		{"ab", 0},
+		{"ab", 17}, // stored already
+		{"a", 8},   // a prefix of "ab": its stop is unique
+		{"abc", 16},
+		{"b", 6}, // 0x61, 0x62
+		{"ac", 15},
+		{"aba", 22},
+		{"", 0},
+	} {
+		uniq, err := k.Insert(skeys(tc.key))
+		if err != nil || len(uniq) != 1 || uniq[0] != tc.uniq {
+			t.Errorf("Insert(%q) = %v, %v; want [%v]", tc.key, uniq, err, tc.uniq)
+		}
+	}
+	want := []string{"", "a", "ab", "aba", "abc", "ac", "b"}
+	if got := retrieveall(t, k, 1); !slices.Equal(got, want) {
+		t.Errorf("Retrieve = %q, want %q", got, want)
+	}
+}
+
+/*
+ * inserts racing each other on the same blocks all make it
+ */
+func TestInsertConcurrent(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	var wg sync.WaitGroup
+	want := []string{}
+
+	for w := 0; w < 4; w++ {
+		for i := 0; i < 50; i++ {
+			want = append(want, fmt.Sprintf("%03d-%v", i, w))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := k.Insert(skeys(fmt.Sprintf("%03d-%v", i, w))); err != nil {
+					t.Errorf("Insert: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	slices.Sort(want)
+	if got := retrieveall(t, k, 1); !slices.Equal(got, want) {
+		t.Errorf("Retrieve returned %v keys, want %v", len(got), len(want))
+	}
+}
+
+/*
+ * a bucket handing out blocks themselves, the way a copyless one does: once armed, the next linked Fetch
+ * of block bn reads it emptied half way through a write, which leaves it as it was
+ */
+type tearing struct {
+	bucket.Bucket
+	mu    sync.Mutex
+	bn    bucket.Block
+	armed bool
+}
+
+func (t *tearing) Fetch(d bucket.Block, withlink bool) (*bucket.Buf, bucket.Link, error) {
+	buf, l, err := t.Bucket.Fetch(d, withlink)
+	t.mu.Lock()
+	torn := err == nil && withlink && d == t.bn && t.armed
+	t.armed = t.armed && !torn
+	t.mu.Unlock()
+	if torn {
+		same := slices.Clone(*buf)
+		t.Bucket.Replace(d, &same, 0, bucket.NOLINK, false)
+		clear(*buf)
+	}
+	return buf, l, err
+}
+
+func (t *tearing) arm(bn bucket.Block) {
+	t.mu.Lock()
+	t.bn, t.armed = bn, true
+	t.mu.Unlock()
+}
+
+/*
+ * a block read while it is written is read again: walks find what is stored, not what they happened to see
+ */
+func TestFetchTorn(t *testing.T) {
+	bkt := &tearing{Bucket: newcounting(64)}
+	k, err := New(Config{Bucket: bkt})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	want := []string{"ab", "abc", "b", fmt.Sprintf("c%0100d", 1)}
+	insert(t, k, want...)
+
+	bkt.arm(k.Root)
+	if got := retrieveall(t, k, 1); !slices.Equal(got, want) {
+		t.Errorf("Retrieve reading the root torn = %.8q, want %.8q", got, want)
+	}
+	bkt.arm(k.Root)
+	got := []string{}
+	for key, err := range k.Scan(skeys("")) {
+		if err != nil {
+			t.Fatalf("Scan reading the root torn: %v", err)
+		}
+		got = append(got, kstrings([][]Key{key})...)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Scan reading the root torn = %.8q, want %.8q", got, want)
+	}
+}
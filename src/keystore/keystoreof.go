@@ -0,0 +1,164 @@
+package keystore
+
+import (
+	"iter"
+)
+
+/*
+ * the KeyStore methods over keys of elements E, e.g. Keystoreof[uint64]{Keystore: ks}.Insert(key).
+ * the store works on bits whatever they are held in: keys are converted to Key on the way in, and back on the way out.
+ * only these are generic; the store itself, its blocks and its Tokens go by Key, of Keyelem.
+ */
+type Keystoreof[E Keyelems] struct {
+	Keystore *Keystore
+}
+
+/*
+ * a Cursor handing out keys of elements E
+ */
+type Cursorof[E Keyelems] struct {
+	*Cursor
+}
+
+/*
+ * k held in elements of type F, a byte at a time; an invalid k (too few Bits for Bitlen) stays invalid.
+ */
+func Keyas[F, E Keyelems](k Keyof[E]) Keyof[F] {
+	if !k.valid() {
+		return Keyof[F]{Bitlen: k.Bitlen}
+	}
+	we, wf := elembits[E](), elembits[F]()
+	c := Keyof[F]{Bitlen: k.Bitlen, Bits: make([]F, (k.Bitlen+wf-1)/wf)}
+
+	for i := uint(0); i < k.Bitlen; i += 8 { // elements are whole bytes
+		b := uint8(k.Bits[i/we] >> (we - 8 - i%we))
+		c.Bits[i/wf] |= F(b) << (wf - 8 - i%wf)
+	}
+	c.trim()
+	return c
+}
+
+func keysas[F, E Keyelems](key []Keyof[E]) []Keyof[F] {
+	if key == nil {
+		return nil
+	}
+	c := make([]Keyof[F], len(key))
+
+	for d := range key {
+		c[d] = Keyas[F](key[d])
+	}
+	return c
+}
+
+func (s Keystoreof[E]) Insert(key []Keyof[E], shorthands ...int) ([]int, error) {
+	return s.Keystore.Insert(keysas[Keyelem](key), shorthands...)
+}
+
+func (s Keystoreof[E]) InsertWith(key []Keyof[E], o InsertOptions) ([]int, error) {
+	return s.Keystore.InsertWith(keysas[Keyelem](key), o)
+}
+
+func (s Keystoreof[E]) Delete(key []Keyof[E], exact ...[]bool) error {
+	return s.Keystore.Delete(keysas[Keyelem](key), exact...)
+}
+
+func (s Keystoreof[E]) DeleteWith(key []Keyof[E], o DeleteOptions) error {
+	return s.Keystore.DeleteWith(keysas[Keyelem](key), o)
+}
+
+func (s Keystoreof[E]) Replace(key []Keyof[E], withkey []Keyof[E], exact ...[]bool) error {
+	return s.Keystore.Replace(keysas[Keyelem](key), keysas[Keyelem](withkey), exact...)
+}
+
+func (s Keystoreof[E]) ReplaceWith(key []Keyof[E], withkey []Keyof[E], o ReplaceOptions) error {
+	return s.Keystore.ReplaceWith(keysas[Keyelem](key), keysas[Keyelem](withkey), o)
+}
+
+func (s Keystoreof[E]) Retrieve(key []Keyof[E], more ...interface{}) ([][]Keyof[E], error) {
+	keys, err := s.Keystore.Retrieve(keysas[Keyelem](key), more...)
+	return retrieved[E](keys), err
+}
+
+func (s Keystoreof[E]) RetrieveWith(key []Keyof[E], o RetrieveOptions) ([][]Keyof[E], error) {
+	keys, err := s.Keystore.RetrieveWith(keysas[Keyelem](key), o)
+	return retrieved[E](keys), err
+}
+
+func retrieved[E Keyelems](keys [][]Key) [][]Keyof[E] {
+	if keys == nil {
+		return nil
+	}
+	c := make([][]Keyof[E], len(keys))
+
+	for i := range keys {
+		c[i] = keysas[E](keys[i])
+	}
+	return c
+}
+
+func (s Keystoreof[E]) Put(key []Keyof[E], value []byte) error {
+	return s.Keystore.Put(keysas[Keyelem](key), value)
+}
+
+func (s Keystoreof[E]) PutWith(key []Keyof[E], value []byte, o PutOptions) error {
+	return s.Keystore.PutWith(keysas[Keyelem](key), value, o)
+}
+
+func (s Keystoreof[E]) CompareAndSwap(key []Keyof[E], expect, value []byte) error {
+	return s.Keystore.CompareAndSwap(keysas[Keyelem](key), expect, value)
+}
+
+func (s Keystoreof[E]) Get(key []Keyof[E]) ([]byte, error) {
+	return s.Keystore.Get(keysas[Keyelem](key))
+}
+
+func (s Keystoreof[E]) RetrieveValues(key []Keyof[E], o RetrieveOptions) ([][]Keyof[E], [][]byte, error) {
+	keys, values, err := s.Keystore.RetrieveValues(keysas[Keyelem](key), o)
+	return retrieved[E](keys), values, err
+}
+
+func (s Keystoreof[E]) Cursor(key []Keyof[E], more ...interface{}) (*Cursorof[E], error) {
+	c, err := s.Keystore.Cursor(keysas[Keyelem](key), more...)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursorof[E]{c}, nil
+}
+
+func (s Keystoreof[E]) CursorWith(key []Keyof[E], o RetrieveOptions) (*Cursorof[E], error) {
+	c, err := s.Keystore.CursorWith(keysas[Keyelem](key), o)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursorof[E]{c}, nil
+}
+
+func (c *Cursorof[E]) Key() []Keyof[E] {
+	return keysas[E](c.Cursor.Key())
+}
+
+func (s Keystoreof[E]) Scan(key []Keyof[E], more ...interface{}) iter.Seq2[[]Keyof[E], error] {
+	return scanned[E](s.Keystore.Scan(keysas[Keyelem](key), more...))
+}
+
+func (s Keystoreof[E]) ScanWith(key []Keyof[E], o RetrieveOptions) iter.Seq2[[]Keyof[E], error] {
+	return scanned[E](s.Keystore.ScanWith(keysas[Keyelem](key), o))
+}
+
+func (s Keystoreof[E]) ScanReverse(key []Keyof[E], more ...interface{}) iter.Seq2[[]Keyof[E], error] {
+	return scanned[E](s.Keystore.ScanReverse(keysas[Keyelem](key), more...))
+}
+
+func (s Keystoreof[E]) ScanReverseWith(key []Keyof[E], o RetrieveOptions) iter.Seq2[[]Keyof[E], error] {
+	return scanned[E](s.Keystore.ScanReverseWith(keysas[Keyelem](key), o))
+}
+
+func scanned[E Keyelems](seq iter.Seq2[[]Key, error]) iter.Seq2[[]Keyof[E], error] {
+	return func(yield func([]Keyof[E], error) bool) {
+		for key, err := range seq {
+			if !yield(keysas[E](key), err) {
+				return
+			}
+		}
+	}
+}
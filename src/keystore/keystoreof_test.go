@@ -0,0 +1,82 @@
+package keystore
+
+import (
+	"slices"
+	"testing"
+)
+
+/*
+ * Keyas keeps the bits whatever the element, and an invalid key invalid
+ */
+func TestKeyas(t *testing.T) {
+	k := bitkey[uint64]("10110100111010011101001110100111010011101001110100111010011101001110")
+
+	for _, c := range []Keyof[uint64]{Keyas[uint64](Keyas[uint8](k)), Keyas[uint64](Keyas[uint16](k)), Keyas[uint64](Keyas[uint32](k))} {
+		if !c.Equal(k) {
+			t.Errorf("%v went through narrower elements as %v", k, c)
+		}
+	}
+	if c := Keyas[uint8](Keyof[uint64]{Bitlen: 3, Bits: []uint64{^uint64(0)}}); c.String() != "111" || c.Bits[0] != 0xe0 {
+		t.Errorf("Keyas of 111 with bits set past Bitlen = %v, %x", c, c.Bits)
+	}
+	if bad := Keyas[uint8](Keyof[uint64]{Bitlen: 65, Bits: []uint64{0}}); bad.valid() {
+		t.Errorf("Keyas of an invalid key = %v, a valid one", bad)
+	}
+}
+
+/*
+ * a store over 64 bit elements takes and hands out keys of them, with the bits the same as over bytes
+ */
+func TestKeystoreof(t *testing.T) {
+	ks, _ := newstore(t, 64, Config{Dims: 2, Dimpace: Roundrobin(2), Values: true})
+	s := Keystoreof[uint64]{Keystore: ks}
+	w := func(bits string) Keyof[uint64] { return bitkey[uint64](bits) }
+	keys := [][]Keyof[uint64]{
+		{w("1"), w("0")},
+		{w("10110011101100111011001110110011101100111011001110110011101100111011"), w("01")},
+		{w("10110011101100111011001110110011101100111011001110110011101100111"), w("011")},
+	}
+
+	for i, key := range keys {
+		if err := s.Put(key, []byte{byte(i)}); err != nil {
+			t.Fatalf("Put(%v): %v", key, err)
+		}
+	}
+	got, values, err := s.RetrieveValues([]Keyof[uint64]{{}, {}}, RetrieveOptions{Matchlen: map[int]int{0: 0, 1: 0}})
+	if err != nil || len(got) != len(keys) {
+		t.Fatalf("RetrieveValues = %v, %v", got, err)
+	}
+	for _, i := range []int{0, 2, 1} {
+		j := slices.IndexFunc(got, func(key []Keyof[uint64]) bool { return slices.EqualFunc(key, keys[i], Keyof[uint64].Equal) })
+		if j < 0 || !slices.Equal(values[j], []byte{byte(i)}) {
+			t.Errorf("key %v came back at %v with %v", keys[i], j, values)
+		}
+		if v, err := s.Get(keys[i]); err != nil || !slices.Equal(v, []byte{byte(i)}) {
+			t.Errorf("Get(%v) = %v, %v", keys[i], v, err)
+		}
+	}
+
+	bytekeys, _ := ks.Retrieve([]Key{{}, {}}, map[int]int{0: 0, 1: 0})
+	n := 0
+	for key, err := range s.Scan([]Keyof[uint64]{{}, {}}, map[int]int{0: 0, 1: 0}) {
+		if err != nil || n >= len(bytekeys) || !Keyas[Keyelem](key[0]).Equal(bytekeys[n][0]) || !Keyas[Keyelem](key[1]).Equal(bytekeys[n][1]) {
+			t.Errorf("Scan key %v: %v, %v; want %v", n, key, err, bytekeys)
+		}
+		n++
+	}
+	if n != len(bytekeys) {
+		t.Errorf("Scan handed out %v keys, want %v", n, len(bytekeys))
+	}
+
+	if err := s.Delete(keys[1], []bool{true, true}); err != nil {
+		t.Fatalf("Delete(%v): %v", keys[1], err)
+	}
+	c, err := s.Cursor([]Keyof[uint64]{keys[2][0], {}}, map[int]int{0: int(keys[2][0].Bitlen) + 1, 1: 0})
+	if err != nil {
+		t.Fatalf("Cursor: %v", err)
+	}
+	defer c.Close()
+	if !c.Next() || !slices.EqualFunc(c.Key(), keys[2], Keyof[uint64].Equal) || c.Next() {
+		t.Errorf("Cursor over %v after deleting %v: %v, %v", keys[2], keys[1], c.Key(), c.Err())
+	}
+}
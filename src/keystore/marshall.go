@@ -1,6 +1,7 @@
 package keystore
 
 import (
+	"bufio"
 	"compress/gzip"
 	"errors"
 	"fmt"
@@ -30,6 +31,8 @@ type blockwrap struct {
 
 var ErrCorrupt = errors.New("corrupt data")
 
+const maxstrbits = 1<<14 - 1 // 6 bits in the short string header + 8 more in the long one
+
 // @@@ TODO: use pointers crossover in Writers (ErrShortWrite) as trigger for split when marshalling
 
 func marshall_basic(x interface{}, w io.Writer) (int64, error) {
@@ -81,7 +84,7 @@ func (f *forkwrap) WriteTo(w io.Writer) (n int64, err error) {
 		return
 	}
 
-	n += int64(appendbits(uint(len(f.fe)), f.ptrwidth))
+	n += int64(appendbits(uint(len(f.fe)-2), f.ptrwidth)) // a fork has at least 2 entries
 	for _, e := range f.fe {
 		n += int64(appendbits(e.segidx, f.ptrwidth))
 		if err != nil {
@@ -106,32 +109,84 @@ func (s *str) WriteTo(w io.Writer) (n int64, err error) {
 	var k int
 
 	if s.bitlen < 64 {
-		w.Write([]byte{byte(b & 0xff)})
-		n = 1
+		k, err = w.Write([]byte{byte(b & 0xff)})
+		n = int64(k)
 	} else {
-		n, _ = marshall_basic(b|2, w)
+		n, err = marshall_basic(b|2, w)
+	}
+	if err != nil {
+		return
 	}
 	k, err = w.Write(s.bits)
 	n += int64(k)
 	return
 }
 
+/*
+ * break a segment's symbols into strings: runs of bits, each ending at a stop (if any) or at maxstrbits.
+ */
+func mkstrings(syms []uint8, align uint) []str {
+	strs := []str{}
+
+	for len(syms) > 0 {
+		s := str{align: align}
+
+		for s.bitlen < maxstrbits && s.bitlen < uint(len(syms)) && syms[s.bitlen] != symstop {
+			s.bitlen++
+		}
+		s.bits = make([]byte, (s.bitlen+align+7)/8)
+		for i, sym := range syms[:s.bitlen] {
+			if sym == 1 {
+				p := align + uint(i)
+				s.bits[p/8] |= 0x80 >> (p & 7)
+			}
+		}
+		if syms = syms[s.bitlen:]; len(syms) > 0 && syms[0] == symstop {
+			s.has_stop = true
+			syms = syms[1:]
+		}
+		strs = append(strs, s)
+		align = (align + s.bitlen) & 7
+	}
+	return strs
+}
+
+func flatten(strs []str) []uint8 {
+	syms := []uint8{}
+
+	for _, s := range strs {
+		for i := uint(0); i < s.bitlen; i++ {
+			p := s.align + i
+			syms = append(syms, (s.bits[p/8]>>(7-(p&7)))&1)
+		}
+		if s.has_stop {
+			syms = append(syms, symstop)
+		}
+	}
+	return syms
+}
+
 func (sw *segwrap) WriteTo(w io.Writer) (n int64, err error) {
+	sw.strings = mkstrings(sw.syms, sw.stralign)
 	b := uint8((uint(len(sw.strings))&7)<<5 | (sw.stralign&7)<<2)
 
 	switch {
 	case (sw.has_remote && sw.has_fork) || (!sw.has_fork && sw.has_stop):
 		panic("corrupt block")
 	case sw.has_remote:
-		n, _ = sw.r.WriteTo(sw.wback) // back for remote
+		if n, err = sw.r.WriteTo(sw.wback); err != nil { // back for remote
+			return
+		}
 		b |= 1
 	case sw.has_stop:
 		b |= 3
 	case sw.has_fork:
 		b |= 2
 	}
-	_, err = w.Write([]byte{byte(b), byte(len(sw.strings) >> 3)})
-	n = int64(2)
+	if _, err = w.Write([]byte{byte(b), byte(len(sw.strings) >> 3)}); err != nil {
+		return
+	}
+	n += 2
 	k := int64(0)
 	for _, s := range sw.strings {
 		if k, err = s.WriteTo(w); err != nil {
@@ -163,12 +218,16 @@ func (bw *blockwrap) WriteTo(w io.Writer) (n int64, err error) {
 }
 
 func marshall(b *block, buf *buff, compress bool) (int, error) {
-	w := io.Writer(newwriter(buf))
-	bw := blockwrap{wback: &w.(*writer).revwriter, block: *b}
+	w := newwriter(buf)
+	bw := blockwrap{wback: &w.revwriter, block: *b}
 
 	if compress {
-		w = gzip.NewWriter(w)
-		defer w.(io.WriteCloser).Close()
+		zw := gzip.NewWriter(w)
+		n, err := bw.WriteTo(zw)
+		if cerr := zw.Close(); err == nil {
+			err = cerr // this is where a compressed block finds out it does not fit
+		}
+		return int(n), err
 	}
 	n, err := bw.WriteTo(w)
 	return int(n), err
@@ -233,13 +292,13 @@ func (s *str) ReadFrom(r io.Reader) (int64, error) {
 		if b, err := r.(io.ByteReader).ReadByte(); err != nil {
 			return 0, ErrCorrupt
 		} else {
-			bitlen |= uint(b) << 8
+			bitlen |= uint(b) << 6
 			nread++
 		}
 	}
 
-	s = &str{bits: make([]byte, (bitlen+s.align+7)/8), bitlen: bitlen, has_stop: ((b & 1) == 1)}
-	if n, err := r.Read(s.bits); err != nil {
+	*s = str{bits: make([]byte, (bitlen+s.align+7)/8), bitlen: bitlen, align: s.align, has_stop: ((b & 1) == 1)}
+	if n, err := io.ReadFull(r, s.bits); err != nil {
 		return nread + int64(n), ErrCorrupt
 	} else {
 		return nread + int64(n), nil
@@ -264,9 +323,9 @@ func (f *forkwrap) ReadFrom(r io.Reader) (int64, error) {
 			return (uint16(prevbyte) >> (from & 7)) & ((1 << length) - 1), n
 		}
 		l := 8 - (from & 7)
-		k, m := getbits(from, l)
+		k := (uint16(prevbyte) >> (from & 7)) & ((1 << l) - 1)
 		j, i := getbits(from+l, length-l)
-		return k | j<<l, m + n + i
+		return k | j<<l, n + i
 	}
 
 	b, n := getbits(0, f.ptrwidth)
@@ -291,12 +350,12 @@ func (seg *segwrap) ReadFrom(forw io.Reader) (int64, error) {
 	var b [2]byte
 	nread := int64(len(b))
 
-	if _, err := forw.Read(b[:]); err != nil {
+	if _, err := io.ReadFull(forw, b[:]); err != nil {
 		return 0, ErrCorrupt
 	}
 	stralign := uint(b[0]>>2) & 7
 	seg.stralign = stralign
-	nstr := (uint(b[0]>>5) & 7) | uint(b[1]<<3)
+	nstr := (uint(b[0]>>5) & 7) | uint(b[1])<<3
 	for seg.strings = make([]str, 0, nstr); nstr > 0; nstr-- {
 		s := str{align: stralign}
 
@@ -308,6 +367,7 @@ func (seg *segwrap) ReadFrom(forw io.Reader) (int64, error) {
 		seg.strings = append(seg.strings, s)
 		stralign = (stralign + s.bitlen) & 7
 	}
+	seg.syms = flatten(seg.strings)
 	if seg.has_remote = ((b[0] & 3) == 1); seg.has_remote {
 		n, err := seg.r.ReadFrom(seg.rback)
 		return nread + n, err
@@ -329,9 +389,9 @@ func (bw *blockwrap) ReadFrom(forw io.Reader) (int64, error) {
 	demarshall_basic(&nseg, forw)
 	bw.block = block{seg: make([]segment, 0, nseg)}
 	bw.segidxbits = uint(bits.Len(uint(nseg)))
-	seg := segwrap{rback: bw.rback, ptrwidth: bw.segidxbits}
 
 	for ; nseg > 0; nseg-- {
+		seg := segwrap{rback: bw.rback, ptrwidth: bw.segidxbits}
 		if n, err := seg.ReadFrom(forw); err != nil {
 			return 0, err
 		} else {
@@ -342,16 +402,25 @@ func (bw *blockwrap) ReadFrom(forw io.Reader) (int64, error) {
 	return nread, nil
 }
 
+/*
+ * a zeroed buffer demarshalls to an empty block, also when compressed.
+ */
 func demarshall(b *buff, compressed bool) (*block, error) {
-	r := io.Reader(newreader(b, 0, 0))
-	bw := blockwrap{rback: &r.(*reader).revreader}
+	fr := newreader(b, 0, 0)
+	r := io.Reader(fr)
+	bw := blockwrap{rback: &fr.revreader}
 
 	if compressed {
-		err := error(nil)
-		if r, err = gzip.NewReader(r); err != nil {
-			return nil, err
+		if len(*b) > 1 && (*b)[0] == 0 && (*b)[1] == 0 {
+			return &block{}, nil
+		}
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, ErrCorrupt
 		}
-		defer r.(io.ReadCloser).Close()
+		defer zr.Close()
+		zr.Multistream(false)
+		r = bufio.NewReader(zr) // parsing wants a ByteReader
 	}
 
 	_, err := bw.ReadFrom(r)
@@ -0,0 +1,126 @@
+package keystore
+
+/*
+ * typed arguments for the ...With entry points; see KeyStore for what they mean.
+ * the variadic entry points take the same arguments told apart by type, and are kept as adapters;
+ * either way, invalid arguments fail with ErrInvalid.
+ */
+type InsertOptions struct {
+	Shorthands int    // minimum total # of bits for a shorthand; 0 for none
+	Value      []byte // for a store keeping values
+	IfAbsent   bool   // ErrExists if key is stored already
+}
+
+type DeleteOptions struct {
+	Exact []bool // nil for prefix in all dimensions
+}
+
+type ReplaceOptions struct {
+	Exact     []bool
+	IfPresent bool // ErrNotFound if oldkey is not stored, ErrExists if newkey is; rather than ErrShared
+}
+
+type PutOptions struct {
+	IfAbsent  bool // ErrExists if key is stored already
+	IfPresent bool // ErrNotFound if it is not
+	Compare   bool // ErrConflict if its value is not Expect; ErrNotFound if there is none
+	Expect    []byte
+}
+
+type RetrieveOptions struct {
+	Shorthand bool
+	Matchlen  map[int]int
+	Reverse   []bool
+	Maxkeys   int   // 0 for all
+	From      Token // for a Cursor, or a Retrieve picking up where one left off
+}
+
+func (k Keystore) checkkey(key []Key) error {
+	switch {
+	case len(key) == 0:
+		return ErrInvalid
+	case len(key) > 1 && k.Dimpace == nil:
+		return ErrDims
+	case k.Dims > 0 && len(key) != k.Dims:
+		return ErrInvalid
+	}
+	for _, kd := range key {
+		if !kd.valid() {
+			return ErrInvalid
+		}
+	}
+	return nil
+}
+
+func checkexact(key []Key, exact []bool) error {
+	if len(exact) > len(key) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func (o *RetrieveOptions) check(key []Key) error {
+	switch {
+	case o.Shorthand && (o.Matchlen != nil || o.Reverse != nil):
+		return ErrInvalid
+	case len(o.Reverse) > len(key) || o.Maxkeys < 0:
+		return ErrInvalid
+	}
+	for d, m := range o.Matchlen {
+		if d < 0 || d >= len(key) || m < 0 {
+			return ErrInvalid
+		}
+	}
+	return nil
+}
+
+func insertargs(more []int) (InsertOptions, error) {
+	o := InsertOptions{}
+
+	switch len(more) {
+	case 0:
+	case 1:
+		o.Shorthands = more[0]
+	default:
+		return o, ErrInvalid
+	}
+	return o, nil
+}
+
+func exactargs(more [][]bool) ([]bool, error) {
+	switch len(more) {
+	case 0:
+		return nil, nil
+	case 1:
+		return more[0], nil
+	}
+	return nil, ErrInvalid
+}
+
+func retrieveargs(more []interface{}) (RetrieveOptions, error) {
+	o := RetrieveOptions{}
+	var seen [5]bool // each type once
+
+	for i := range more {
+		var arg int
+		switch v := more[i].(type) {
+		case bool:
+			arg, o.Shorthand = 0, v
+		case map[int]int:
+			arg, o.Matchlen = 1, v
+		case []bool:
+			arg, o.Reverse = 2, v
+		case int:
+			arg, o.Maxkeys = 3, v
+		case Token:
+			arg, o.From = 4, v
+		default:
+			return o, ErrInvalid
+		}
+		if seen[arg] {
+			return o, ErrInvalid
+		}
+		seen[arg] = true
+	}
+	return o, nil
+}
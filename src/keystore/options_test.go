@@ -0,0 +1,49 @@
+package keystore
+
+import "testing"
+
+/*
+ * arguments that make no sense fail with ErrInvalid, and leave the store as it was
+ */
+func TestOptions(t *testing.T) {
+	k, _ := newstore(t, 64, Config{Dims: 2, Dimpace: alternate})
+	if _, err := k.Insert(skeys("a", "b")); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	odd := Key{Bitlen: 70, Bits: []Keyelem{0}}
+
+	cases := []struct {
+		what string
+		err  error
+	}{
+		{"Insert with two shorthands", func() error { _, err := k.Insert(skeys("a", "c"), 1, 2); return err }()},
+		{"Insert with negative shorthands", func() error { _, err := k.InsertWith(skeys("a", "c"), InsertOptions{Shorthands: -1}); return err }()},
+		{"Insert of one dimension", func() error { _, err := k.Insert(skeys("a")); return err }()},
+		{"Insert of no dimension", func() error { _, err := k.Insert(nil); return err }()},
+		{"Insert of a key short of bits", func() error { _, err := k.Insert([]Key{odd, skey("c")}); return err }()},
+		{"Delete with two Exact", k.Delete(skeys("a", "b"), []bool{true}, []bool{true})},
+		{"Delete with three dimensions Exact", k.DeleteWith(skeys("a", "b"), DeleteOptions{Exact: []bool{true, true, true}})},
+		{"Replace with two Exact", k.Replace(skeys("a", "b"), skeys("a", "c"), nil, nil)},
+		{"Retrieve with a string", func() error { _, err := k.Retrieve(skeys("a", "b"), "x"); return err }()},
+		{"Retrieve with two maxkeys", func() error { _, err := k.Retrieve(skeys("a", "b"), 1, 2); return err }()},
+		{"Retrieve with two matchlens", func() error { _, err := k.Retrieve(skeys("a", "b"), map[int]int{0: 1}, map[int]int{1: 1}); return err }()},
+		{"Cursor with two shorthands", func() error { _, err := k.Cursor(skeys("a", "b"), false, true); return err }()},
+		{"Retrieve with negative maxkeys", func() error { _, err := k.Retrieve(skeys("a", "b"), -1); return err }()},
+		{"Retrieve with matchlen for dimension 2", func() error { _, err := k.Retrieve(skeys("a", "b"), map[int]int{2: 0}); return err }()},
+		{"Retrieve with negative matchlen", func() error { _, err := k.Retrieve(skeys("a", "b"), map[int]int{0: -1}); return err }()},
+		{"Retrieve with three reverse", func() error { _, err := k.Retrieve(skeys("a", "b"), []bool{true, true, true}); return err }()},
+		{"shorthand Retrieve with matchlen", func() error {
+			_, err := k.RetrieveWith(skeys("a", "b"), RetrieveOptions{Shorthand: true, Matchlen: map[int]int{}})
+			return err
+		}()},
+		{"Cursor with a bad token", func() error { _, err := k.Cursor(skeys("a", "b"), Token{9}); return err }()},
+	}
+	for _, c := range cases {
+		if c.err != ErrInvalid {
+			t.Errorf("%v = %v, want ErrInvalid", c.what, c.err)
+		}
+	}
+	if got := retrieveall(t, k, 2); len(got) != 1 || got[0] != "a/b" {
+		t.Errorf("stored %q", got)
+	}
+}
@@ -0,0 +1,140 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+/*
+ * a key is stored as a stream of symbols: the bits of all of its dimensions, interleaved according to Dimpace,
+ * where each dimension is followed by a stop once its bits are used up.
+ * stops make the streams prefix free: no stored key is a prefix of another, and a key ends where
+ * (and only where) all of its dimensions have stopped.
+ * stops sort before bits.
+ */
+const symstop = 2
+
+func symorder(sym uint8) uint8 {
+	return (sym + 1) % 3
+}
+
+type pacer struct {
+	key     []Key
+	dim     Dimpace
+	keybit  []int         // per dimension bit num; Bitlen+1 if seen stop
+	bitnum  uint          // bits consumed, not counting stops; this is what Dimpace gets
+	stopmap map[uint]uint // stopped dimensions and their lengths
+	values  bool          // the last dimension holds a value, see values.go
+}
+
+var ErrPace = errors.New("key cannot be paced")
+
+/*
+ * the dimension of the next symbol.
+ * a dimension picked by Dimpace once its bits are used up stops.
+ * picking a stopped dimension (again) means the key is to end: the dimensions that have not stopped yet
+ * must all be used up as well, and stop in dimension order; last is set for these.
+ * a value dimension is left out of this, and comes once all the others have stopped.
+ */
+func (p *pacer) nextdim() (d uint, last bool, err error) {
+	n := uint(len(p.key))
+
+	if p.values {
+		if n--; len(p.stopmap) == int(n) {
+			return n, false, nil
+		}
+	}
+	if p.dim != nil {
+		d, _ = p.dim(p.bitnum, p.stopmap) // the rest of the run is not relied upon
+	}
+	if _, stopped := p.stopmap[d]; d < n && !stopped {
+		return d, false, nil
+	}
+	if d >= n {
+		return d, false, fmt.Errorf("%w: bit %v paced to dimension %v of %v", ErrPace, p.bitnum, d, n)
+	}
+	for e := range n {
+		if _, stopped := p.stopmap[e]; !stopped {
+			return e, true, nil
+		}
+	}
+	return d, false, ErrCorrupt // all stopped; should not be asked
+}
+
+/*
+ * the next symbol and its dimension, without consuming it.
+ */
+func (p *pacer) peek() (uint, uint8, error) {
+	d, last, err := p.nextdim()
+
+	switch {
+	case err != nil:
+		return d, 0, err
+	case uint(p.keybit[d]) == p.key[d].Bitlen:
+		return d, symstop, nil
+	case last:
+		return d, 0, fmt.Errorf("%w: bit %v paced to a stopped dimension, with %v bits left in dimension %v",
+			ErrPace, p.bitnum, p.key[d].Bitlen-uint(p.keybit[d]), d)
+	}
+	return d, p.key[d].bit(uint(p.keybit[d])), nil
+}
+
+func (p *pacer) take(d uint, sym uint8) {
+	if sym == symstop {
+		p.stopmap[d] = p.key[d].Bitlen
+	} else {
+		p.bitnum++
+	}
+	p.keybit[d]++
+}
+
+func (p *pacer) done() bool {
+	return len(p.stopmap) == len(p.key)
+}
+
+/*
+ * copy for walking another branch; keys are copied too, as a pacer decoding stored keys appends to them.
+ */
+func (p *pacer) clone() *pacer {
+	c := &pacer{key: make([]Key, len(p.key)), dim: p.dim, keybit: slices.Clone(p.keybit), bitnum: p.bitnum, stopmap: maps.Clone(p.stopmap), values: p.values}
+
+	for d, k := range p.key {
+		c.key[d] = Key{Bitlen: k.Bitlen, Bits: slices.Clone(k.Bits)}
+	}
+	return c
+}
+
+func (p *pacer) snapshot() []int {
+	return slices.Clone(p.keybit)
+}
+
+/*
+ * consume the remaining symbols
+ */
+func (p *pacer) rest() ([]uint8, error) {
+	syms := []uint8{}
+
+	for !p.done() {
+		d, sym, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		p.take(d, sym)
+		syms = append(syms, sym)
+	}
+	return syms, nil
+}
+
+/*
+ * per dimension position of the first unique bit, for a pacer that stopped where its key diverges from the stored ones.
+ */
+func (p *pacer) uniq() []int {
+	uniq := make([]int, len(p.key))
+
+	for d, b := range p.keybit {
+		uniq[d] = min(b, int(p.key[d].Bitlen))
+	}
+	return uniq
+}
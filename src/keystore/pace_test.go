@@ -0,0 +1,70 @@
+package keystore
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+/*
+ * a bit of each of two dimensions in turn, and the other one's bits once one has stopped
+ */
+func alternate(b uint, stopmap map[uint]uint) (uint, uint) {
+	if _, stopped := stopmap[0]; stopped {
+		return 1, 0
+	}
+	if _, stopped := stopmap[1]; stopped {
+		return 0, 0
+	}
+	return b % 2, 0
+}
+
+func TestInsertDims(t *testing.T) {
+	k, _ := newstore(t, 64, Config{Dimpace: alternate, Dims: 2})
+
+	for _, tc := range []struct {
+		key  []string
+		uniq []int
+	}{
+		{[]string{"ab", "x"}, []int{0, 0}},
+		{[]string{"ab", "y"}, []int{8, 7}},  // x and y part at their last bit, when 8 bits of ab are in
+		{[]string{"ab", "xz"}, []int{9, 8}}, // x stopped at 8, ab takes the bits from 9 on alone
+		{[]string{"a", "x"}, []int{8, 8}},   // stops where ab goes on
+		{[]string{"ab", "y"}, []int{17, 9}},
+	} {
+		uniq, err := k.Insert(skeys(tc.key...))
+		if err != nil || !slices.Equal(uniq, tc.uniq) {
+			t.Errorf("Insert(%q) = %v, %v; want %v", tc.key, uniq, err, tc.uniq)
+		}
+	}
+	want := []string{"a/x", "ab/x", "ab/xz", "ab/y"}
+	if got := retrieveall(t, k, 2); !slices.Equal(got, want) {
+		t.Errorf("Retrieve = %q, want %q", got, want)
+	}
+}
+
+/*
+ * keys a Dimpace cannot place fail before anything is written
+ */
+func TestInsertPace(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dimpace Dimpace
+	}{
+		{"stopped", func(b uint, stopmap map[uint]uint) (uint, uint) { return 0, 0 }},
+		{"out of range", func(b uint, stopmap map[uint]uint) (uint, uint) { return 2 + b%2, 0 }},
+	} {
+		k, _ := newstore(t, 64, Config{Dimpace: tc.dimpace})
+		if _, err := k.Insert(skeys("a", "b")); !errors.Is(err, ErrPace) {
+			t.Errorf("%v: Insert = %v, want ErrPace", tc.name, err)
+		}
+		if got := retrieveall(t, k, 2); len(got) != 0 {
+			t.Errorf("%v: stored %q", tc.name, got)
+		}
+	}
+
+	k, _ := newstore(t, 64, Config{})
+	if _, err := k.Insert(skeys("a", "b")); err != ErrDims {
+		t.Errorf("Insert of two dimensions without a Dimpace = %v, want ErrDims", err)
+	}
+}
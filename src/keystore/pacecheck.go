@@ -0,0 +1,123 @@
+package keystore
+
+import (
+	"fmt"
+)
+
+/*
+ * a wrong Dimpace shows only when some key fails to pace, or worse, when a non-exact delete turns out not to be atomic;
+ * these run a Dimpace on key lengths alone, the way Insert would, to find out beforehand.
+ * pacing cannot go on forever: each symbol uses up a bit or stops a dimension, so a pacer that never gets to a
+ * dimension, or keeps at one that is used up, ends the key early and fails with ErrPace, which says where.
+ * (a Dimpace that does not return at all is not caught.)
+ */
+
+/*
+ * how a key paces
+ */
+type Pacing struct {
+	Dims     []uint // the dimension of each bit, stops left out
+	Stops    []uint // Stops[d] is the # of bits paced before dimension d stopped
+	Together bool   // no bits after the first stop: all dimensions exhaust at once, see KeyStore.Delete
+}
+
+/*
+ * pace a key with dimensions of lens[d] bits.
+ * on error, what was paced up to the failure is returned as well.
+ */
+func Simulate(dimpace Dimpace, lens []uint) (pc Pacing, err error) {
+	key := make([]Key, len(lens))
+	p := &pacer{key: key, dim: dimpace, keybit: make([]int, len(key)), stopmap: make(map[uint]uint)}
+	pc = Pacing{Stops: make([]uint, len(key)), Together: true}
+
+	if len(key) > 1 && dimpace == nil {
+		return pc, ErrDims
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: Dimpace panicked at bit %v: %v", ErrPace, len(pc.Dims), r)
+		}
+	}()
+	w := elembits[Keyelem]()
+	for d, l := range lens {
+		key[d] = Key{Bitlen: l, Bits: make([]Keyelem, (l+w-1)/w)}
+	}
+	for !p.done() {
+		d, sym, err := p.peek()
+		if err != nil {
+			return pc, err
+		}
+		p.take(d, sym)
+		switch {
+		case sym == symstop:
+			pc.Stops[d] = uint(len(pc.Dims))
+		case len(p.stopmap) > 0:
+			pc.Together = false
+			fallthrough
+		default:
+			pc.Dims = append(pc.Dims, d)
+		}
+	}
+	return pc, nil
+}
+
+/*
+ * check that dimpace paces keys of dims dimensions up to maxlen bits each: all of the same length, and with
+ * each dimension in turn empty, half as long as, or the only one longer than, the others.
+ * these are what a Dimpace ignoring the stopmap gets wrong; it does not prove every combination of lengths paces.
+ */
+func Checkpace(dimpace Dimpace, dims, maxlen uint) error {
+	check := func(lens []uint) error {
+		if _, err := Simulate(dimpace, lens); err != nil {
+			return fmt.Errorf("dimension lengths %v: %w", lens, err)
+		}
+		return nil
+	}
+	lens := make([]uint, dims)
+
+	for d := range lens {
+		lens[d] = maxlen
+	}
+	if err := check(lens); err != nil {
+		return err
+	}
+	for d := range lens {
+		for _, l := range []uint{0, maxlen / 2} {
+			lens[d] = l
+			if err := check(lens); err != nil {
+				return err
+			}
+		}
+		lens[d] = maxlen
+	}
+	for d := range lens {
+		clear(lens)
+		lens[d] = maxlen
+		if err := check(lens); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * whether keys can all be inserted: err tells of the first that cannot be paced.
+ * together is whether they all exhaust at once (see Pacing), which deleting or replacing them by non-exact
+ * keys needs in order to be atomic.
+ */
+func Checkkeys(dimpace Dimpace, keys [][]Key) (together bool, err error) {
+	together = true
+
+	for i, key := range keys {
+		lens := make([]uint, len(key))
+		for d, k := range key {
+			lens[d] = k.Bitlen
+		}
+		pc, err := Simulate(dimpace, lens)
+		if err != nil {
+			return false, fmt.Errorf("key %v: %w", i, err)
+		}
+		together = together && pc.Together
+	}
+	return together, nil
+}
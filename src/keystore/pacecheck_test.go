@@ -0,0 +1,98 @@
+package keystore
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+/*
+ * bit b to dimension b%2, stopped or not
+ */
+func ignorestops(b uint, stopmap map[uint]uint) (uint, uint) {
+	return b % 2, 0
+}
+
+/*
+ * Simulate tells the dimension of each bit, where each one stops, and whether they all stop at once
+ */
+func TestSimulate(t *testing.T) {
+	for _, tc := range []struct {
+		lens     []uint
+		dims     []uint
+		stops    []uint
+		together bool
+	}{
+		{[]uint{2, 2}, []uint{0, 1, 0, 1}, []uint{4, 4}, true},
+		{[]uint{2, 1}, []uint{0, 1, 0}, []uint{3, 3}, true},
+		{[]uint{0, 3}, []uint{1, 1, 1}, []uint{0, 3}, false},
+		{[]uint{3, 0}, []uint{0, 0, 0}, []uint{3, 1}, false},
+	} {
+		pc, err := Simulate(Roundrobin(2), tc.lens)
+		if err != nil || !slices.Equal(pc.Dims, tc.dims) || !slices.Equal(pc.Stops, tc.stops) || pc.Together != tc.together {
+			t.Errorf("Simulate(%v) = %+v, %v; want %v %v %v", tc.lens, pc, err, tc.dims, tc.stops, tc.together)
+		}
+	}
+}
+
+/*
+ * a pacer failing a key fails with ErrPace, having paced what it could
+ */
+func TestSimulateFails(t *testing.T) {
+	pc, err := Simulate(ignorestops, []uint{3, 1})
+	if !errors.Is(err, ErrPace) || !slices.Equal(pc.Dims, []uint{0, 1, 0}) {
+		t.Errorf("Simulate of a pacer ignoring stops = %+v, %v; want ErrPace after [0 1 0]", pc, err)
+	}
+	boom := func(b uint, stopmap map[uint]uint) (uint, uint) { panic("boom") }
+	if _, err := Simulate(boom, []uint{1, 1}); !errors.Is(err, ErrPace) {
+		t.Errorf("Simulate of a panicking pacer = %v, want ErrPace", err)
+	}
+	if _, err := Simulate(nil, []uint{1, 1}); err != ErrDims {
+		t.Errorf("Simulate without a pacer = %v, want ErrDims", err)
+	}
+}
+
+/*
+ * Checkpace passes the standard pacers, and fails ones starving a dimension or ignoring the stopmap
+ */
+func TestCheckpace(t *testing.T) {
+	for _, dims := range []uint{1, 2, 3} {
+		if err := Checkpace(Roundrobin(dims), dims, 8); err != nil {
+			t.Errorf("Checkpace(Roundrobin(%v)) = %v", dims, err)
+		}
+	}
+	if err := Checkpace(Chunked(2, 3), 2, 16); err != nil {
+		t.Errorf("Checkpace(Chunked(2, 3)) = %v", err)
+	}
+	starve := func(b uint, stopmap map[uint]uint) (uint, uint) { return 0, 0 }
+	for name, pace := range map[string]Dimpace{"ignoring stops": ignorestops, "starving": starve} {
+		if err := Checkpace(pace, 2, 8); !errors.Is(err, ErrPace) {
+			t.Errorf("Checkpace of a pacer %v = %v, want ErrPace", name, err)
+		}
+	}
+}
+
+/*
+ * Checkkeys tells whether keys pace, and whether they all exhaust at once
+ */
+func TestCheckkeys(t *testing.T) {
+	for _, tc := range []struct {
+		keys     [][]string
+		together bool
+	}{
+		{[][]string{{"a", "b"}, {"ab", "cd"}}, true},
+		{[][]string{{"a", "b"}, {"ab", "c"}}, false},
+		{[][]string{{"a", "b"}, {"ab", ""}}, false},
+	} {
+		keys := [][]Key{}
+		for _, key := range tc.keys {
+			keys = append(keys, skeys(key...))
+		}
+		if together, err := Checkkeys(Roundrobin(2), keys); err != nil || together != tc.together {
+			t.Errorf("Checkkeys(%q) = %v, %v; want %v", tc.keys, together, err, tc.together)
+		}
+	}
+	if _, err := Checkkeys(ignorestops, [][]Key{skeys("a", "b"), skeys("ab", "c")}); !errors.Is(err, ErrPace) {
+		t.Errorf("Checkkeys of a pacer ignoring stops = %v, want ErrPace", err)
+	}
+}
@@ -0,0 +1,89 @@
+package keystore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+ * a Dimpace is a bare function, which cannot be stored with the data it paced: a store records the name
+ * of the pacer instead, with the parameters it was made with, and a store reopened gets its Dimpace
+ * from the registry by these (see Create and Open).
+ * a name is a stable identifier: once keys have been stored with it, what it paces them to must not change.
+ */
+type Pacermaker func(params []byte) (Dimpace, error)
+
+var (
+	ErrRegistered = errors.New("pacer already registered")
+	ErrNoPacer    = errors.New("no such pacer")
+)
+
+var pacers = struct {
+	sync.RWMutex
+	m map[string]Pacermaker
+}{m: map[string]Pacermaker{}}
+
+/*
+ * add a family of pacers under name; a name can only be registered once.
+ */
+func RegisterPacer(name string, m Pacermaker) error {
+	pacers.Lock()
+	defer pacers.Unlock()
+
+	switch _, taken := pacers.m[name]; {
+	case name == "" || m == nil:
+		return ErrInvalid
+	case taken:
+		return fmt.Errorf("%w: %q", ErrRegistered, name)
+	}
+	pacers.m[name] = m
+	return nil
+}
+
+/*
+ * the Dimpace registered under name, made with params.
+ */
+func LookupPacer(name string, params []byte) (Dimpace, error) {
+	pacers.RLock()
+	m := pacers.m[name]
+	pacers.RUnlock()
+
+	if m == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNoPacer, name)
+	}
+	dimpace, err := m(params)
+	if err != nil {
+		return nil, fmt.Errorf("pacer %q: %w", name, err)
+	}
+	return dimpace, nil
+}
+
+/*
+ * params the way the standard pacers take them: a uvarint each.
+ */
+func Pacerparams(v ...uint) []byte {
+	params := []byte{}
+
+	for _, u := range v {
+		params = binary.AppendUvarint(params, uint64(u))
+	}
+	return params
+}
+
+/*
+ * the uvarints params is made of
+ */
+func pacerargs(params []byte) ([]uint, error) {
+	v := []uint{}
+
+	for len(params) > 0 {
+		u, l := binary.Uvarint(params)
+		if l <= 0 {
+			return nil, ErrInvalid
+		}
+		v, params = append(v, uint(u)), params[l:]
+	}
+	return v, nil
+}
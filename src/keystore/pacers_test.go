@@ -0,0 +1,82 @@
+package keystore
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"bucket_mem"
+)
+
+/*
+ * a name is registered once, and looked up with the params its pacer is made with
+ */
+func TestRegisterPacer(t *testing.T) {
+	mk := func(params []byte) (Dimpace, error) { return alternate, nil }
+
+	if err := RegisterPacer("test-alternate", mk); err != nil {
+		t.Fatalf("RegisterPacer: %v", err)
+	}
+	if err := RegisterPacer("test-alternate", mk); !errors.Is(err, ErrRegistered) {
+		t.Errorf("RegisterPacer again = %v, want ErrRegistered", err)
+	}
+	if err := RegisterPacer("", mk); err != ErrInvalid {
+		t.Errorf("RegisterPacer without a name = %v, want ErrInvalid", err)
+	}
+	if _, err := LookupPacer("test-none", nil); !errors.Is(err, ErrNoPacer) {
+		t.Errorf("LookupPacer of a name not registered = %v, want ErrNoPacer", err)
+	}
+	for _, name := range []string{"roundrobin", "morton", "dimmajor", "chunked", "weighted"} {
+		if _, err := LookupPacer(name, []byte{0x80}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("LookupPacer(%q) with params cut short = %v, want ErrInvalid", name, err)
+		}
+	}
+
+	pace, err := LookupPacer("chunked", Pacerparams(2, 3))
+	if err != nil {
+		t.Fatalf("LookupPacer: %v", err)
+	}
+	want := Chunked(2, 3)
+	for b := uint(0); b < 20; b++ {
+		d, _ := pace(b, map[uint]uint{})
+		if w, _ := want(b, map[uint]uint{}); d != w {
+			t.Errorf("chunked 2, 3 looked up gives dimension %v for bit %v, not %v", d, b, w)
+		}
+	}
+}
+
+/*
+ * a store is reopened with the pacer it was created with, and not with another
+ */
+func TestPacerRecorded(t *testing.T) {
+	bkt := bucket_mem.New(64)
+	k, err := Create(Config{Bucket: bkt, Dims: 2, Pacer: "chunked", PacerParams: Pacerparams(2, 4)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, s := range [][]string{{"ab", "cd"}, {"ab", "ce"}, {"b", "c"}} {
+		if _, err := k.Insert(skeys(s...)); err != nil {
+			t.Fatalf("Insert(%q): %v", s, err)
+		}
+	}
+	want := retrieveall(t, k, 2)
+
+	again, err := Open(Config{Bucket: bkt})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got := retrieveall(t, again, 2); !slices.Equal(got, want) {
+		t.Errorf("reopened with %q, want %q", got, want)
+	}
+	for _, c := range []Config{
+		{Pacer: "morton", PacerParams: Pacerparams(2)},
+		{Pacer: "chunked", PacerParams: Pacerparams(2, 1)},
+	} {
+		c.Bucket = bkt
+		if _, err := Open(c); !errors.Is(err, ErrMismatch) {
+			t.Errorf("Open with %q %v = %v, want ErrMismatch", c.Pacer, c.PacerParams, err)
+		}
+	}
+	if _, err := New(Config{Bucket: bucket_mem.New(64), Dims: 2, Pacer: "test-none"}); !errors.Is(err, ErrNoPacer) {
+		t.Errorf("New with a pacer not registered = %v, want ErrNoPacer", err)
+	}
+}
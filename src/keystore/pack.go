@@ -0,0 +1,219 @@
+package keystore
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"bucket"
+)
+
+/*
+ * branch off segment i at symbol off, where a key with the remaining symbols tail diverges from it.
+ * the segment keeps its head, and forks into its old continuation and the tail, with shorthand matches oldsm and sm.
+ */
+func (b *block) branch(i, off int, tail []uint8, oldsm, sm uint) {
+	old := b.seg[i]
+	old.syms = old.syms[off:]
+	b.seg[i] = segment{syms: b.seg[i].syms[:off:off], has_fork: true, stralign: old.stralign}
+	b.seg = append(b.seg, old)
+	b.fork(i, len(b.seg)-1, oldsm)
+	b.addbranch(i, tail, sm)
+}
+
+func (b *block) addbranch(i int, tail []uint8, sm uint) {
+	b.seg = append(b.seg, segment{syms: tail})
+	b.fork(i, len(b.seg)-1, sm)
+}
+
+/*
+ * drop branch e of the fork ending segment i; a fork left with a single branch is merged into the segment.
+ */
+func (b *block) unbranch(i, e int) {
+	seg := &b.seg[i]
+
+	seg.f.fe = slices.Delete(slices.Clone(seg.f.fe), e, e+1)
+	if len(seg.f.fe) == 1 {
+		child := b.seg[seg.f.fe[0].segidx]
+		child.syms = append(slices.Clip(seg.syms), child.syms...)
+		child.stralign = seg.stralign
+		*seg = child
+	}
+	b.seg = subtree(b.seg, 0)
+}
+
+/*
+ * add child to the fork ending segment i; fork entries are kept in symbol order.
+ */
+func (b *block) fork(i, child int, sm uint) {
+	f := &b.seg[i].f
+	sym := symorder(b.seg[child].syms[0])
+	j := 0
+
+	for ; j < len(f.fe) && symorder(b.seg[f.fe[j].segidx].syms[0]) < sym; j++ {
+	}
+	f.fe = slices.Insert(f.fe, j, forkelem{segidx: uint(child), shorthandmatch: sm})
+}
+
+/*
+ * copy of the subtree of segments rooted at segs[root], renumbered depth first.
+ */
+func subtree(segs []segment, root int) []segment {
+	sub := []segment{}
+	var walk func(i int) uint
+
+	walk = func(i int) uint {
+		j := uint(len(sub))
+		sub = append(sub, segs[i])
+		if segs[i].has_fork {
+			fe := slices.Clone(segs[i].f.fe)
+			for e := range fe {
+				fe[e].segidx = walk(int(fe[e].segidx))
+			}
+			sub[j].f.fe = fe
+		}
+		return j
+	}
+	walk(root)
+	return sub
+}
+
+const stubsize = 2 + 1 + 16 // segment header, a short string and a remote pointer
+
+/*
+ * a remote pointer and the one symbol its fork needs, which spill would only swap for the same again
+ */
+func (seg *segment) stub() bool {
+	return seg.has_remote && !seg.has_fork && len(seg.syms) <= 1
+}
+
+/*
+ * rough marshalled size of a segment
+ */
+func segsize(seg *segment) int {
+	n := 2 + 1 + len(seg.syms)/8
+
+	for _, s := range seg.syms {
+		if s == symstop {
+			n += 2
+		}
+	}
+	if seg.has_remote {
+		n += 16
+	}
+	if seg.has_fork {
+		n += 1 + (len(seg.f.fe)*(16+4)+7)/8
+	}
+	return n
+}
+
+/*
+ * rough marshalled size of the subtree rooted at each segment
+ */
+func (b *block) sizes() []int {
+	size := make([]int, len(b.seg))
+	var walk func(i int) int
+
+	walk = func(i int) int {
+		size[i] = segsize(&b.seg[i])
+		if b.seg[i].has_fork {
+			for _, e := range b.seg[i].f.fe {
+				size[i] += walk(int(e.segidx))
+			}
+		}
+		return size[i]
+	}
+	walk(0)
+	return size
+}
+
+/*
+ * carve out of b what is to be moved to a new block; the returned function replaces it with a remote pointer
+ * once the new block is kept.
+ * moves the largest subtree hanging off a fork that would fit in a block by itself, or the largest one if none would;
+ * a branch keeps its first symbol, which its fork needs.
+ * if there is nothing worth moving, b is a chain of symbols and the second half of the chain is moved.
+ */
+func (b *block) spill(bufsize int) (*block, func(remote)) {
+	size := b.sizes()
+	fit := func(i int) bool { return size[i] < bufsize*3/4 }
+	c := -1
+
+	for i := range b.seg {
+		if !b.seg[i].has_fork {
+			continue
+		}
+		for _, e := range b.seg[i].f.fe {
+			j := int(e.segidx)
+			if size[j] <= stubsize || b.seg[j].stub() {
+				continue
+			}
+			if c < 0 || (fit(j) && !fit(c)) || (fit(j) == fit(c) && size[j] > size[c]) {
+				c = j
+			}
+		}
+	}
+	if c >= 0 {
+		nb := &block{seg: subtree(b.seg, c)}
+		nb.seg[0].syms = nb.seg[0].syms[1:]
+		return nb, func(r remote) {
+			b.seg[c] = segment{syms: b.seg[c].syms[:1:1], has_remote: true, r: r, stralign: b.seg[c].stralign}
+			b.seg = subtree(b.seg, 0)
+		}
+	}
+
+	syms := b.seg[0].syms
+	if len(syms) < 2 {
+		return nil, nil
+	}
+	h := len(syms) / 2
+	nb := &block{seg: subtree(b.seg, 0)}
+	nb.seg[0].syms = syms[h:]
+	return nb, func(r remote) {
+		b.seg = []segment{{syms: syms[:h:h], has_remote: true, r: r, stralign: b.seg[0].stralign}}
+	}
+}
+
+/*
+ * marshall b into buf, spilling over to new blocks until it fits.
+ * return the newly kept blocks; on failure, these are discarded.
+ */
+func (k *Keystore) pack(b *block, buf *buff) ([]bucket.Block, error) {
+	kept := []bucket.Block{}
+	fail := func(err error) ([]bucket.Block, error) {
+		if len(kept) > 0 {
+			k.Bucket.Discard(kept...)
+		}
+		return nil, err
+	}
+
+	for {
+		_, err := marshall(b, buf, k.Compressed)
+		switch {
+		case err == nil:
+			return kept, nil
+		case !errors.Is(err, io.ErrShortWrite):
+			return fail(err)
+		}
+		nb, stub := b.spill(k.Bufsize)
+		if nb == nil {
+			return fail(bucket.ErrBufsize)
+		}
+
+		nbuf, _, err := k.Bucket.Fetch(bucket.NOBLOCK, false)
+		if err != nil {
+			return fail(err)
+		}
+		sub, err := k.pack(nb, (*buff)(nbuf))
+		kept = append(kept, sub...)
+		if err != nil {
+			k.Bucket.Release(nbuf)
+			return fail(err)
+		}
+		bn, gen, err := k.Bucket.Keep(nbuf, true)
+		if err != nil {
+			return fail(err)
+		}
+		kept = append(kept, bn)
+		stub(remote{bn: bn, gen: gen})
+	}
+}
@@ -0,0 +1,199 @@
+package keystore
+
+import (
+	"errors"
+	"bucket"
+)
+
+/*
+ * Replace follows newkey down to where it leaves the stored keys. that spot is where oldkey and newkey part too,
+ * and the precondition holds iff a single stored key lies below it, matching oldkey.
+ * the tail of that key is swapped for that of newkey in the block holding the spot, which is a single linked write.
+ * if the old tail runs on through blocks of its own, these are sealed first, as Delete does, so that nobody
+ * writes below the spot meanwhile; the write then replaces the key at once, and they are discarded.
+ */
+var ErrShared = errors.New("key to replace is not alone under the head it shares with the new key")
+
+func (k Keystore) Replace(oldkey []Key, newkey []Key, more ...[]bool) error {
+	exact, err := exactargs(more)
+	if err != nil {
+		return err
+	}
+	return k.ReplaceWith(oldkey, newkey, ReplaceOptions{Exact: exact})
+}
+
+func (k Keystore) ReplaceWith(oldkey []Key, newkey []Key, o ReplaceOptions) error {
+	exact := make([]bool, len(oldkey))
+
+	if err := k.checkkey(oldkey); err != nil {
+		return err
+	}
+	if err := k.checkkey(newkey); err != nil {
+		return err
+	}
+	if len(newkey) != len(oldkey) {
+		return ErrInvalid
+	}
+	if err := checkexact(oldkey, o.Exact); err != nil {
+		return err
+	}
+	copy(exact, o.Exact)
+	var err error
+
+	if k.Values {
+		err = k.replacevalue(oldkey, newkey, exact)
+	} else {
+		err = k.replacekey(oldkey, newkey, exact)
+	}
+	switch {
+	case err != ErrExists && err != ErrShared:
+		return err
+	case !o.IfPresent:
+		return ErrShared
+	}
+	if _, lerr := k.stored(oldkey, exact); lerr != nil { // ErrNotFound comes first
+		return lerr
+	}
+	return err
+}
+
+func (k Keystore) replacekey(oldkey, newkey []Key, exact []bool) error {
+	for {
+		err := k.replace(oldkey, newkey, exact)
+		if _, lost := err.(bucket.Link); !lost {
+			return err
+		}
+	}
+}
+
+/*
+ * a single attempt at rewriting in place
+ */
+func (k Keystore) replace(oldkey, newkey []Key, exact []bool) error {
+	state := searchstate{k: &k}
+	p := state.downtree_prep(newkey)
+
+	b, err := state.fetch(remote{bn: k.Root}, p.snapshot())
+	if err != nil {
+		return err
+	}
+	if b, err = state.downtree(b, p); err != nil {
+		return err
+	}
+	defer k.Bucket.Release((*bucket.Buf)(b.buf))
+
+	switch {
+	case len(b.seg) > 0 && k.Values && valued(p) && !sameholder(oldkey, newkey): // with a value of its own
+		return ErrExists
+	case len(b.seg) == 0 || len(state.forkpath) > 0: // nothing, or more than one key, below
+		return ErrShared
+	case len(state.bitpath) == 0: // newkey is stored already
+		if !matches(newkey, oldkey, exact) {
+			return ErrExists
+		}
+		return nil
+	}
+	i, off := state.segpath[len(state.segpath)-1].segidx, state.bitpath[0].bitnum
+	sp := p.clone()
+	for d, n := range p.uniq() {
+		sp.key[d] = Key{}
+		for j := uint(0); j < uint(n); j++ {
+			sp.key[d].append(p.key[d].bit(j))
+		}
+	}
+	sole, blocks, err := k.sole(b, i, off, sp, nil)
+	switch {
+	case err != nil:
+		if modified(k.Bucket, b.address, state.rempath[len(state.rempath)-1].link) {
+			return state.rempath[len(state.rempath)-1].link
+		}
+		return err
+	case !sole || !matches(sp.key, oldkey, exact):
+		return ErrShared
+	}
+
+	tail, err := p.rest()
+	if err != nil {
+		return err
+	}
+	seg := &b.seg[i]
+	seg.syms = append(seg.syms[:off:off], tail...)
+	seg.has_remote, seg.r = false, remote{}
+	olds, err := k.seal(blocks)
+	if err != nil {
+		return err
+	}
+	if err = state.commit(b); err != nil {
+		k.unseal(blocks, olds)
+		return err
+	}
+	if len(blocks) > 0 {
+		bns := []bucket.Block{}
+		for _, r := range blocks {
+			bns = append(bns, r.rem.bn)
+		}
+		err = k.Bucket.Discard(bns...)
+	}
+	return err
+}
+
+/*
+ * decode into p the stored keys going on from symbol off of segment i of b; false if there are more than one.
+ * also returns the blocks they run through, with links.
+ */
+func (k Keystore) sole(b *block, i, off int, p *pacer, blocks []remcomp) (bool, []remcomp, error) {
+	seg := &b.seg[i]
+
+	for _, sym := range seg.syms[off:] {
+		if p.done() {
+			return false, blocks, ErrCorrupt
+		}
+		d, last, err := p.nextdim()
+		switch {
+		case err != nil:
+			return false, blocks, err
+		case last && sym != symstop:
+			return false, blocks, ErrCorrupt
+		}
+		if sym != symstop {
+			p.key[d].append(sym)
+		}
+		p.take(d, sym)
+	}
+	switch {
+	case seg.has_fork:
+		return false, blocks, nil
+	case seg.has_remote:
+		buf, link, err := k.Bucket.Fetch(seg.r.bn, true)
+		if err != nil {
+			return false, blocks, err
+		}
+		nb, err := k.parse(seg.r.bn, buf, link)
+		k.Bucket.Release(buf)
+		if err != nil {
+			return false, blocks, err
+		}
+		if len(nb.seg) == 0 { // sealed by a Delete
+			return false, blocks, link
+		}
+		return k.sole(nb, 0, 0, p, append(blocks, remcomp{rem: seg.r, link: link}))
+	case !p.done():
+		return false, blocks, ErrCorrupt
+	}
+	return true, blocks, nil
+}
+
+/*
+ * stored matches key, in full in the exact dimensions and as a prefix in the others
+ */
+func matches(stored, key []Key, exact []bool) bool {
+	for d := range key {
+		switch {
+		case stored[d].Bitlen < key[d].Bitlen, exact[d] && stored[d].Bitlen != key[d].Bitlen:
+			return false
+		case !stored[d].Substr(0, key[d].Bitlen).Equal(key[d]):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"bucket"
+)
+
+var errBroken = errors.New("bucket broken for the test")
+
+/*
+ * a bucket whose fail-th write fails
+ */
+type failing struct {
+	bucket.Bucket
+	mu           sync.Mutex
+	writes, fail int
+}
+
+func (f *failing) Replace(d bucket.Block, b *bucket.Buf, off uint, l bucket.Link, decref bool) error {
+	f.mu.Lock()
+	if len(*b) > 0 {
+		f.writes++
+	}
+	broken := f.writes == f.fail
+	f.mu.Unlock()
+	if broken && len(*b) > 0 {
+		return errBroken
+	}
+	return f.Bucket.Replace(d, b, off, l, decref)
+}
+
+func (f *failing) breakat(n int) {
+	f.mu.Lock()
+	f.writes, f.fail = 0, n
+	f.mu.Unlock()
+}
+
+/*
+ * a key alone under the head it shares with the new one is rewritten, in its block or the long way
+ */
+func TestReplace(t *testing.T) {
+	k, bkt := newstore(t, 64, Config{})
+	root := bkt.blocks()
+	long := fmt.Sprintf("%0100d", 7) // runs on through blocks of its own
+	insert(t, k, "abc", "abd", "y"+long)
+
+	steps := []struct {
+		old, new string
+		exact    []bool
+		want     []string
+	}{
+		{"abc", "abb", []bool{true}, []string{"abb", "abd", "y" + long}},
+		{"abb", "abbyz", nil, []string{"abbyz", "abd", "y" + long}},
+		{"y", "z" + long, nil, []string{"abbyz", "abd", "z" + long}},
+		{"z" + long, "y" + long, []bool{true}, []string{"abbyz", "abd", "y" + long}},
+	}
+	for _, s := range steps {
+		if err := k.ReplaceWith(skeys(s.old), skeys(s.new), ReplaceOptions{Exact: s.exact}); err != nil {
+			t.Fatalf("Replace(%.8q, %.8q): %v", s.old, s.new, err)
+		}
+		if got := retrieveall(t, k, 1); !slices.Equal(got, s.want) {
+			t.Errorf("after Replace(%.8q, %.8q): %.8q, want %.8q", s.old, s.new, got, s.want)
+		}
+	}
+	if err := k.Delete(skeys("")); err != nil {
+		t.Fatalf("Delete of everything: %v", err)
+	}
+	if n := bkt.blocks(); n != root {
+		t.Errorf("%v blocks left in an empty store, want %v", n, root)
+	}
+}
+
+/*
+ * no single key to replace, or the new one stored already: nothing changes, and IfPresent tells which
+ */
+func TestReplaceShared(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	insert(t, k, "ab", "ac", "b")
+	want := []string{"ab", "ac", "b"}
+
+	cases := []struct {
+		old, new string
+		exact    []bool
+		err, ifp error
+	}{
+		{"a", "az", nil, ErrShared, ErrShared},           // ab and ac both match
+		{"a", "a", []bool{true}, ErrShared, ErrNotFound}, // not stored
+		{"q", "r", nil, ErrShared, ErrNotFound},
+		{"ab", "b", []bool{true}, ErrShared, ErrExists},
+	}
+	for _, c := range cases {
+		for _, ifpresent := range []bool{false, true} {
+			want := c.err
+			if ifpresent {
+				want = c.ifp
+			}
+			err := k.ReplaceWith(skeys(c.old), skeys(c.new), ReplaceOptions{Exact: c.exact, IfPresent: ifpresent})
+			if err != want {
+				t.Errorf("Replace(%q, %q, %v) with IfPresent %v = %v, want %v", c.old, c.new, c.exact, ifpresent, err, want)
+			}
+		}
+	}
+	if got := retrieveall(t, k, 1); !slices.Equal(got, want) {
+		t.Errorf("stored %q, want %q", got, want)
+	}
+}
+
+/*
+ * an old key running on through blocks of its own is left as it was if the write fails, and its blocks go if not
+ */
+func TestReplaceRollback(t *testing.T) {
+	cnt := newcounting(64)
+	bkt := &failing{Bucket: cnt}
+	k, err := New(Config{Bucket: bkt})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	long := fmt.Sprintf("%0100d", 7)
+	insert(t, k, "a", "y"+long)
+	n := cnt.blocks()
+
+	bkt.breakat(2) // the old tail is sealed, the write fails
+	if err := k.Replace(skeys("y"), skeys("z"+long)); err != errBroken {
+		t.Fatalf("Replace on a broken bucket = %v, want %v", err, errBroken)
+	}
+	bkt.breakat(0)
+	if got, want := retrieveall(t, k, 1), []string{"a", "y" + long}; !slices.Equal(got, want) {
+		t.Errorf("stored %.8q, want %.8q", got, want)
+	}
+	if cnt.blocks() != n {
+		t.Errorf("failed Replace left %v blocks, not %v", cnt.blocks(), n)
+	}
+	if err := k.Replace(skeys("y"), skeys("z"+long)); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if got, want := retrieveall(t, k, 1), []string{"a", "z" + long}; !slices.Equal(got, want) {
+		t.Errorf("stored %.8q, want %.8q", got, want)
+	}
+	if cnt.blocks() != n {
+		t.Errorf("Replace of a key as long left %v blocks, not %v", cnt.blocks(), n)
+	}
+}
+
+/*
+ * a key running on through blocks of its own, replaced back and forth: a reader always finds it once,
+ * as one name or the other, and no blocks are left over
+ */
+func TestReplaceConcurrent(t *testing.T) {
+	k, bkt := newstore(t, 64, Config{})
+	long := fmt.Sprintf("%0100d", 5)
+	insert(t, k, "a", "y"+long)
+	n := bkt.blocks()
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+
+	for _, names := range [][2]string{{"y", "z"}, {"z", "y"}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := k.Replace(skeys(names[0]), skeys(names[1]+long)); err != nil && err != ErrShared {
+					t.Errorf("Replace(%q, %q): %v", names[0], names[1], err)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			keys, err := k.Retrieve(skeys(""), map[int]int{0: 0})
+			if got := kstrings(keys); err != nil || len(got) != 2 || got[0] != "a" || got[1][1:] != long {
+				t.Errorf("Retrieve = %.8q, %v", got, err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-done
+	if bkt.blocks() != n {
+		t.Errorf("%v blocks left, want %v", bkt.blocks(), n)
+	}
+}
@@ -80,11 +80,11 @@ func (r *revreader) Seek(offset int64, whence int) (int64, error) {
 		r.off = uint(offset)
 		return offset, nil
 	case io.SeekCurrent:
-		if offset > int64(r.off) {
+		if offset < -int64(r.off) {
 			break
 		}
-		r.off += uint(offset)
-		return offset, nil
+		r.off = uint(int64(r.off) + offset)
+		return int64(r.off), nil
 	}
 	return 0, ErrInvalid
 }
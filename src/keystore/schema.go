@@ -0,0 +1,297 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+/*
+ * a Schema maps records onto keys: field d of a record is dimension d of its key, encoded as in encode.go,
+ * so that keys sort the way the values do.
+ * a record is a struct, whose fields are found by a `keystore:"name"` tag or else by their name, or a tuple ([]any)
+ * in schema order. values that do not fit the schema are rejected with ErrSchema before a key is made of them.
+ * e.g. Schema{{"Tenant", Fieldstring, 0}, {"Region", Fielduint, 16}, {"At", Fieldtime, 0}} for
+ *   struct { Tenant string; Region uint16; At time.Time }, with a Dimpace for 3 dimensions.
+ */
+type Schema []Field
+
+type Field struct {
+	Name   string
+	Type   Fieldtype
+	Bitlen uint // fixed length: the width of a uint or int (64 if 0), the exact length of bytes, strings or keys (any if 0)
+}
+
+type Fieldtype int
+
+const (
+	Fielduint   Fieldtype = iota + 1 // unsigned integers
+	Fieldint                         // signed integers
+	Fieldfloat                       // float32, float64
+	Fieldtime                        // time.Time, decoded in UTC
+	Fieldbool                        // bool
+	Fieldbytes                       // []byte
+	Fieldstring                      // string
+	Fieldkey                         // Key, as is
+)
+
+var ErrSchema = errors.New("value does not fit the schema")
+
+var (
+	timetype = reflect.TypeOf(time.Time{})
+	keytype  = reflect.TypeOf(Key{})
+)
+
+/*
+ * whether s is a schema at all: fields have distinct names, and lengths their types can take.
+ */
+func (s Schema) Check() error {
+	names := map[string]bool{}
+
+	if len(s) == 0 {
+		return ErrInvalid
+	}
+	for _, f := range s {
+		bad := ""
+		switch {
+		case f.Name == "" || names[f.Name]:
+			bad = "missing or repeated name"
+		case f.Type < Fielduint || f.Type > Fieldkey:
+			bad = "no such type"
+		case (f.Type == Fielduint || f.Type == Fieldint) && f.Bitlen > 64:
+			bad = "wider than 64 bits"
+		case (f.Type == Fieldfloat || f.Type == Fieldtime || f.Type == Fieldbool) && f.Bitlen != 0 && f.Bitlen != f.width():
+			bad = "length does not fit the type"
+		case (f.Type == Fieldbytes || f.Type == Fieldstring) && f.Bitlen%8 != 0:
+			bad = "length not in bytes"
+		}
+		if bad != "" {
+			return fmt.Errorf("%w: schema field %q: %v", ErrInvalid, f.Name, bad)
+		}
+		names[f.Name] = true
+	}
+	return nil
+}
+
+/*
+ * the key of record, a struct (or a pointer to one) or a []any
+ */
+func (s Schema) Encode(record any) ([]Key, error) {
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+	vals, err := s.fields(reflect.ValueOf(record), false)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]Key, len(s))
+
+	for d, f := range s {
+		if key[d], err = f.encode(vals[d]); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+/*
+ * decode key into record, a pointer to a struct or to a []any; the latter gets uint64, int64, float64,
+ * time.Time, bool, []byte, string or Key values.
+ * to decode what Retrieve returned, one key at a time.
+ */
+func (s Schema) Decode(key []Key, record any) error {
+	if err := s.Check(); err != nil {
+		return err
+	}
+	if len(key) != len(s) {
+		return fmt.Errorf("%w: %v dimensions, not %v", ErrSchema, len(key), len(s))
+	}
+	vals, err := s.fields(reflect.ValueOf(record), true)
+	if err != nil {
+		return err
+	}
+	for d, f := range s {
+		if err = f.decode(key[d], vals[d]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * the values of the fields of record in schema order; settable ones to decode into if set is.
+ */
+func (s Schema) fields(record reflect.Value, set bool) ([]reflect.Value, error) {
+	vals := make([]reflect.Value, len(s))
+
+	if !record.IsValid() {
+		return nil, fmt.Errorf("%w: no record", ErrSchema)
+	}
+	if record.Kind() == reflect.Pointer && !record.IsNil() {
+		record = record.Elem()
+	} else if set {
+		return nil, fmt.Errorf("%w: can only decode through a pointer, not %v", ErrSchema, record.Type())
+	}
+	switch {
+	case record.Kind() == reflect.Struct:
+		t := record.Type()
+		for d, f := range s {
+			for i := 0; i < t.NumField(); i++ {
+				sf := t.Field(i)
+				if tag, ok := sf.Tag.Lookup("keystore"); sf.IsExported() && (tag == f.Name || !ok && sf.Name == f.Name) {
+					vals[d] = record.Field(i)
+					break
+				}
+			}
+			if !vals[d].IsValid() {
+				return nil, fmt.Errorf("%w: %v has no field %q", ErrSchema, t, f.Name)
+			}
+		}
+	case record.Kind() == reflect.Slice && record.Type().Elem().Kind() == reflect.Interface:
+		if set {
+			record.Set(reflect.MakeSlice(record.Type(), len(s), len(s)))
+		}
+		if record.Len() != len(s) {
+			return nil, fmt.Errorf("%w: %v values, not %v", ErrSchema, record.Len(), len(s))
+		}
+		for d := range vals {
+			if vals[d] = record.Index(d); !set {
+				vals[d] = vals[d].Elem()
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: records are structs or []any, not %v", ErrSchema, record.Type())
+	}
+	return vals, nil
+}
+
+/*
+ * # of bits the key of f takes, 0 for any
+ */
+func (f Field) width() uint {
+	switch f.Type {
+	case Fielduint, Fieldint:
+		if f.Bitlen == 0 {
+			return 64
+		}
+	case Fieldfloat:
+		return 64
+	case Fieldtime:
+		return timebits
+	case Fieldbool:
+		return 1
+	}
+	return f.Bitlen
+}
+
+func (f Field) violation(format string, a ...any) error {
+	return fmt.Errorf("%w: field %q: %v", ErrSchema, f.Name, fmt.Sprintf(format, a...))
+}
+
+func (f Field) encode(v reflect.Value) (Key, error) {
+	n, k := f.width(), Key{}
+
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return k, f.violation("no value")
+	}
+	switch kind := v.Kind(); {
+	case f.Type == Fielduint && kind >= reflect.Uint && kind <= reflect.Uintptr:
+		if u := v.Uint(); n == 64 || u>>n == 0 {
+			k = appendbits(k, u, n)
+		} else {
+			return k, f.violation("%v does not fit %v bits", u, n)
+		}
+	case f.Type == Fieldint && kind >= reflect.Int && kind <= reflect.Int64:
+		if i := v.Int(); n == 64 || i >= -1<<(n-1) && i < 1<<(n-1) {
+			k = appendbits(k, uint64(i)^1<<(n-1), n)
+		} else {
+			return k, f.violation("%v does not fit %v bits", i, n)
+		}
+	case f.Type == Fieldfloat && (kind == reflect.Float32 || kind == reflect.Float64):
+		k = Float64key(v.Float())
+	case f.Type == Fieldtime && v.Type() == timetype:
+		k = Timekey(v.Interface().(time.Time))
+	case f.Type == Fieldbool && kind == reflect.Bool:
+		k = Boolkey(v.Bool())
+	case f.Type == Fieldbytes && kind == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		k = Byteskey(v.Bytes(), false)
+	case f.Type == Fieldstring && kind == reflect.String:
+		k = Stringkey(v.String(), false)
+	case f.Type == Fieldkey && v.Type() == keytype:
+		if k = v.Interface().(Key); !k.valid() {
+			return k, f.violation("invalid key")
+		}
+	default:
+		return k, f.violation("%v is not of its type", v.Type())
+	}
+	if n != 0 && k.Bitlen != n {
+		return k, f.violation("%v bits, not %v", k.Bitlen, n)
+	}
+	return k, nil
+}
+
+/*
+ * the value of k as its natural type
+ */
+func (f Field) value(k Key) (any, error) {
+	n := f.width()
+
+	if n != 0 && k.Bitlen != n {
+		return nil, fmt.Errorf("%w: key of %v bits, not %v", ErrInvalid, k.Bitlen, n)
+	}
+	switch f.Type {
+	case Fielduint:
+		return k.bits(0, n), nil
+	case Fieldint:
+		u := k.bits(0, n) ^ 1<<(n-1)
+		return int64(u<<(64-n)) >> (64 - n), nil
+	case Fieldfloat:
+		return Keyfloat64(k)
+	case Fieldtime:
+		return Keytime(k)
+	case Fieldbool:
+		return Keybool(k)
+	case Fieldbytes:
+		return Keybytes(k, false)
+	case Fieldstring:
+		return Keystring(k, false)
+	}
+	return k, nil
+}
+
+func (f Field) decode(k Key, v reflect.Value) error {
+	if !k.valid() {
+		return f.violation("invalid key")
+	}
+	x, err := f.value(k)
+	if err != nil {
+		return fmt.Errorf("field %q: %w", f.Name, err)
+	}
+	xv := reflect.ValueOf(x)
+
+	switch kind := v.Kind(); {
+	case kind == reflect.Interface:
+		v.Set(xv)
+	case f.Type == Fielduint && kind >= reflect.Uint && kind <= reflect.Uintptr && !v.OverflowUint(xv.Uint()):
+		v.SetUint(xv.Uint())
+	case f.Type == Fieldint && kind >= reflect.Int && kind <= reflect.Int64 && !v.OverflowInt(xv.Int()):
+		v.SetInt(xv.Int())
+	case f.Type == Fieldfloat && (kind == reflect.Float32 || kind == reflect.Float64) && !v.OverflowFloat(xv.Float()):
+		v.SetFloat(xv.Float())
+	case f.Type == Fieldbytes && kind == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(xv.Bytes())
+	case f.Type == Fieldstring && kind == reflect.String:
+		v.SetString(xv.String())
+	case f.Type == Fieldbool && kind == reflect.Bool:
+		v.SetBool(xv.Bool())
+	case xv.Type().AssignableTo(v.Type()):
+		v.Set(xv)
+	default:
+		return f.violation("%v does not fit %v", x, v.Type())
+	}
+	return nil
+}
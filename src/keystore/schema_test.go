@@ -0,0 +1,165 @@
+package keystore
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type visit struct {
+	Tenant string `keystore:"tenant"`
+	Region int8
+	Delta  int16 `keystore:"delta"`
+	Score  float32
+	At     time.Time
+	Known  bool
+	Tag    []byte
+}
+
+var visitschema = Schema{
+	{"tenant", Fieldstring, 0},
+	{"Region", Fieldint, 8},
+	{"delta", Fieldint, 12},
+	{"Score", Fieldfloat, 0},
+	{"At", Fieldtime, 0},
+	{"Known", Fieldbool, 0},
+	{"Tag", Fieldbytes, 16},
+}
+
+/*
+ * structs and tuples come back from their keys as they went in, narrow signed ints at both ends of their range
+ */
+func TestSchemaRoundTrip(t *testing.T) {
+	at := time.Date(1969, 7, 20, 20, 17, 40, 5, time.UTC)
+
+	for _, v := range []visit{
+		{"acme", -128, -2048, -1.5, at, true, []byte{0, 1}},
+		{"", 127, 2047, 0.25, time.Unix(0, 0).UTC(), false, []byte{0xff, 0}},
+		{"x\x00y", -1, 0, float32(math.Inf(1)), at, true, []byte{1, 2}},
+	} {
+		key, err := visitschema.Encode(v)
+		if err != nil {
+			t.Fatalf("Encode(%+v): %v", v, err)
+		}
+		if key[1].Bitlen != 8 || key[2].Bitlen != 12 {
+			t.Errorf("Encode(%+v): dimensions of %v and %v bits, want 8 and 12", v, key[1].Bitlen, key[2].Bitlen)
+		}
+		got := visit{}
+		if err := visitschema.Decode(key, &got); err != nil || !reflect.DeepEqual(got, v) {
+			t.Errorf("Decode(Encode(%+v)) = %+v, %v", v, got, err)
+		}
+		if pkey, err := visitschema.Encode(&v); err != nil || !reflect.DeepEqual(pkey, key) {
+			t.Errorf("Encode of a pointer to %+v = %v, %v; want %v", v, pkey, err, key)
+		}
+	}
+
+	tuple := Schema{{"u", Fielduint, 0}, {"i", Fieldint, 5}, {"s", Fieldstring, 0}, {"k", Fieldkey, 0}}
+	in := []any{uint16(7), -16, "ab", Stringkey("c", true)}
+	key, err := tuple.Encode(in)
+	if err != nil {
+		t.Fatalf("Encode(%v): %v", in, err)
+	}
+	out := []any{}
+	want := []any{uint64(7), int64(-16), "ab", Stringkey("c", true)}
+	if err := tuple.Decode(key, &out); err != nil || !reflect.DeepEqual(out, want) {
+		t.Errorf("Decode(Encode(%v)) = %v, %v; want %v", in, out, err, want)
+	}
+}
+
+/*
+ * narrow signed ints sort as they do at full width
+ */
+func TestSchemaIntOrder(t *testing.T) {
+	s := Schema{{"i", Fieldint, 4}}
+	last := Key{}
+
+	for i := -8; i < 8; i++ {
+		key, err := s.Encode([]any{i})
+		if err != nil {
+			t.Fatalf("Encode(%v): %v", i, err)
+		}
+		if i > -8 && last.Compare(key[0]) >= 0 {
+			t.Errorf("key of %v does not sort before that of %v", i-1, i)
+		}
+		last = key[0]
+	}
+}
+
+/*
+ * values not fitting the schema fail with ErrSchema, schemas that are not ones with ErrInvalid
+ */
+func TestSchemaInvalid(t *testing.T) {
+	v := visit{Tag: []byte{1, 2}}
+	s := Schema{{"i", Fieldint, 4}, {"u", Fielduint, 8}}
+	key, _ := s.Encode([]any{0, uint(0)})
+
+	for name, err := range map[string]error{
+		"int too wide":      func() error { _, err := s.Encode([]any{8, uint(0)}); return err }(),
+		"int too narrow":    func() error { _, err := s.Encode([]any{-9, uint(0)}); return err }(),
+		"uint too wide":     func() error { _, err := s.Encode([]any{0, uint(256)}); return err }(),
+		"wrong type":        func() error { _, err := s.Encode([]any{"0", uint(0)}); return err }(),
+		"missing value":     func() error { _, err := s.Encode([]any{0}); return err }(),
+		"nil value":         func() error { _, err := s.Encode([]any{nil, uint(0)}); return err }(),
+		"no such field":     func() error { _, err := s.Encode(v); return err }(),
+		"bytes of a length": func() error { v.Tag = []byte{1}; _, err := visitschema.Encode(v); return err }(),
+		"not a record":      func() error { _, err := s.Encode(3); return err }(),
+		"decode by value":   func() error { return s.Decode(key, []any{}) }(),
+		"decode dimensions": func() error { return s.Decode(key[:1], &[]any{}) }(),
+		"decode into narrow": func() error {
+			return Schema{{"Region", Fieldint, 12}}.Decode([]Key{appendbits(Key{}, 0, 12)}, &visit{})
+		}(),
+	} {
+		if !errors.Is(err, ErrSchema) {
+			t.Errorf("%v: %v, want ErrSchema", name, err)
+		}
+	}
+	for _, bad := range []Schema{
+		{},
+		{{"a", Fieldint, 0}, {"a", Fieldint, 0}},
+		{{"", Fieldint, 0}},
+		{{"a", Fieldint, 65}},
+		{{"a", Fieldbool, 2}},
+		{{"a", Fieldstring, 7}},
+		{{"a", Fieldkey + 1, 0}},
+	} {
+		if _, err := bad.Encode([]any{0}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Encode with schema %v = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
+
+/*
+ * records go into a store and come back out of Retrieve
+ */
+func TestSchemaStore(t *testing.T) {
+	s := Schema{{"tenant", Fieldstring, 0}, {"n", Fieldint, 16}}
+	k, _ := newstore(t, 64, Config{Dims: 2, Dimpace: Roundrobin(2)})
+	in := [][]any{{"b", -3}, {"a", 500}, {"a", -500}}
+
+	for _, r := range in {
+		key, err := s.Encode(r)
+		if err != nil {
+			t.Fatalf("Encode(%v): %v", r, err)
+		}
+		if _, err := k.Insert(key); err != nil {
+			t.Fatalf("Insert(%v): %v", r, err)
+		}
+	}
+	keys, err := k.Retrieve([]Key{{}, {}}, map[int]int{0: 0, 1: 0})
+	if err != nil || len(keys) != len(in) {
+		t.Fatalf("Retrieve = %v keys, %v", len(keys), err)
+	}
+	got := map[string]int64{}
+	for _, key := range keys {
+		r := []any{}
+		if err := s.Decode(key, &r); err != nil {
+			t.Fatalf("Decode(%v): %v", key, err)
+		}
+		got[r[0].(string)] += r[1].(int64)
+	}
+	if want := map[string]int64{"a": 0, "b": -3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package keystore
+
+import "slices"
+
+/*
+ * shorthands:
+ * a shorthand is a key cut short in each dimension; the symbols it does not cover (stops included) are unmatched.
+ * a shorthand lookup follows the stored symbols, checking the matched ones and counting the unmatched ones.
+ * at a fork whose next symbol is unmatched, it takes the branch whose shorthandmatch equals that count (mod 16),
+ * or failing that, the branch starting with a stop, if any.
+ *
+ * every stored symbol came in with the tail of some key, its owner. a key's shorthand covers the symbols up to where
+ * it branched off, plus the first few bits of its tail; everything past these is unmatched for it.
+ * a branch's shorthandmatch is kept such that further down, its owner counts shorthandmatch + # of symbols since the fork:
+ *   - a key branching off gets (# of stops so far) - (# of tail bits covered). it covers at least the bit it branched
+ *     off with, and enough bits for the shorthandmatch to differ from those of the other branches and from # of stops
+ *     so far, which keys having stopped a dimension right there count: they are left to the stop branch.
+ *   - when a branch is split, its continuation gets the shorthandmatch of the branch above plus the symbols since.
+ *   - the first key stored owns the symbols from the root, where there is no fork to keep a shorthandmatch;
+ *     it covers a multiple of 16 bits, which leaves it at 0.
+ * shorthands handed out keep working as keys are added; a key whose shorthand comes out ambiguous is still stored,
+ * and Insert says so.
+ */
+
+/*
+ * a shorthand that does not single out the key it was made for; Keys holds (some of) the keys it matches.
+ */
+type AmbiguityError struct {
+	Keys [][]Key
+}
+
+func (e *AmbiguityError) Error() string {
+	return "ambiguous shorthand"
+}
+
+/*
+ * how many bits of tail a key branching off with stops stopped dimensions covers in its shorthand, and the branch's
+ * shorthandmatch; sib holds those of the other branches of the fork, and root is set for the first key stored.
+ * at least need bits are covered if that works out, fewer otherwise. false if there is no shorthand to be had.
+ */
+func shorthandext(tail []uint8, stops uint, sib []uint, need uint, root bool) (uint, uint, bool) {
+	nbits := uint(0)
+	for _, s := range tail {
+		if s != symstop {
+			nbits++
+		}
+	}
+	need = min(need, nbits)
+	sm := func(b uint) uint { return (stops + 16 - b%16) % 16 }
+	ok := func(b uint) bool {
+		switch {
+		case root:
+			return b%16 == 0
+		case tail[0] == symstop: // found by its shorthandmatch, or for want of one
+			return !slices.Contains(sib, stops) && !slices.Contains(sib, sm(b))
+		}
+		return b > 0 && sm(b) != stops && !slices.Contains(sib, sm(b))
+	}
+
+	for b := need; b <= nbits; b++ {
+		if ok(b) {
+			return b, sm(b), true
+		}
+	}
+	for b := need; b > 0; b-- {
+		if ok(b - 1) {
+			return b - 1, sm(b - 1), true
+		}
+	}
+	for v := uint(0); ; v++ { // keep the other branches' shorthands working
+		if v != stops && !slices.Contains(sib, v) {
+			return need, v, false
+		}
+	}
+}
+
+/*
+ * consume the symbols of tail up to its b'th bit
+ */
+func (p *pacer) skip(tail []uint8, b uint) {
+	for i := 0; b > 0; i++ {
+		d, _, _ := p.nextdim()
+		if p.take(d, tail[i]); tail[i] != symstop {
+			b--
+		}
+	}
+}
+
+/*
+ * check that the shorthand of key with lengths uniq looks up key and nothing else
+ */
+func (k Keystore) resolves(key []Key, uniq []int) error {
+	short := make([]Key, len(key))
+
+	for d := range key {
+		short[d] = key[d].Substr(0, uint(uniq[d]))
+	}
+	found, err := k.Retrieve(short, true)
+	if err != nil {
+		return err
+	}
+	if len(found) == 1 && slices.EqualFunc(found[0], key, Key.Equal) {
+		return nil
+	}
+	return &AmbiguityError{Keys: append(found, key)}
+}
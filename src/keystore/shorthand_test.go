@@ -0,0 +1,122 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"bucket"
+)
+
+func lookup(t *testing.T, k *Keystore, short Key) []string {
+	t.Helper()
+	keys, err := k.Retrieve([]Key{short}, true)
+	if err != nil {
+		t.Errorf("shorthand Retrieve(%v): %v", short, err)
+	}
+	return kstrings(keys)
+}
+
+/*
+ * a shorthand handed out by Insert looks up its key, also once more keys are stored
+ */
+func TestShorthand(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	short := map[string]Key{}
+
+	for i := 0; i < 60; i++ {
+		s := fmt.Sprintf("k%02d", i*7%60)
+		uniq, err := k.Insert(skeys(s), 6)
+		if err != nil {
+			t.Fatalf("Insert(%q): %v", s, err)
+		}
+		if uniq[0] < 6 || uniq[0] > 8*len(s) {
+			t.Errorf("Insert(%q) gave a shorthand of %v bits", s, uniq[0])
+		}
+		short[s] = skey(s).Substr(0, uint(uniq[0]))
+		if got := lookup(t, k, short[s]); !slices.Equal(got, []string{s}) {
+			t.Errorf("shorthand %v of %q looks up %q", short[s], s, got)
+		}
+	}
+	for s, sh := range short {
+		if got := lookup(t, k, sh); !slices.Equal(got, []string{s}) {
+			t.Errorf("shorthand %v of %q looks up %q once all keys are stored", sh, s, got)
+		}
+	}
+	if got := lookup(t, k, skey("x")); len(got) != 0 {
+		t.Errorf("shorthand of no key looks up %q", got)
+	}
+}
+
+/*
+ * the empty key is a prefix of any other: it is stored, but has no shorthand of its own
+ */
+func TestShorthandAmbiguous(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	insert(t, k, "a", "ab")
+
+	_, err := k.Insert(skeys(""), 1)
+	var amb *AmbiguityError
+	if !errors.As(err, &amb) || len(amb.Keys) == 0 {
+		t.Errorf("Insert of the empty key with a shorthand = %v, want an *AmbiguityError", err)
+	}
+	if got := retrieveall(t, k, 1); !slices.Equal(got, []string{"", "a", "ab"}) {
+		t.Errorf("stored %q", got)
+	}
+}
+
+/*
+ * a shorthand matching two keys fails Retrieve, Cursor and Scan alike, handing out neither.
+ * Insert keeps the branches of a fork apart, so the root is rewritten for them to share their shorthandmatch.
+ */
+func TestShorthandAmbiguousWalk(t *testing.T) {
+	k, bkt := newstore(t, 256, Config{})
+	insert(t, k, "a\x00", "a\x80", "b")
+	buf, _, err := bkt.Fetch(k.Root, false)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	b, err := demarshall((*buff)(buf), false)
+	bkt.Release(buf)
+	if err != nil {
+		t.Fatalf("demarshall: %v", err)
+	}
+	for i := range b.seg {
+		if b.seg[i].has_fork {
+			for j := range b.seg[i].f.fe {
+				b.seg[i].f.fe[j].shorthandmatch = 0
+			}
+		}
+	}
+	nbuf := buff(make([]byte, 256))
+	if _, err := marshall(b, &nbuf, false); err != nil {
+		t.Fatalf("marshall: %v", err)
+	}
+	if err := bkt.Replace(k.Root, (*bucket.Buf)(&nbuf), 0, bucket.NOLINK, false); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	var amb *AmbiguityError
+	short := []Key{skey("a")}
+	if keys, err := k.Retrieve(short, true); !errors.As(err, &amb) || len(amb.Keys) != 2 {
+		t.Errorf("shorthand Retrieve = %q, %v; want an *AmbiguityError of 2 keys", kstrings(keys), err)
+	}
+	c, err := k.Cursor(short, true, 2)
+	if err != nil {
+		t.Fatalf("Cursor: %v", err)
+	}
+	for c.Next() {
+		t.Errorf("shorthand Cursor hands out %q", kstrings([][]Key{c.Key()}))
+	}
+	if !errors.As(c.Err(), &amb) {
+		t.Errorf("shorthand Cursor ends with %v, want an *AmbiguityError", c.Err())
+	}
+	for key, err := range k.Scan(short, true) {
+		if !errors.As(err, &amb) {
+			t.Errorf("shorthand Scan = %q, %v; want an *AmbiguityError", kstrings([][]Key{key}), err)
+		}
+	}
+	if keys, err := k.Retrieve([]Key{skey("b")}, true); err != nil || !slices.Equal(kstrings(keys), []string{"b"}) {
+		t.Errorf("shorthand Retrieve of b = %q, %v", kstrings(keys), err)
+	}
+}
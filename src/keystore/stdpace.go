@@ -0,0 +1,151 @@
+package keystore
+
+import (
+	"slices"
+)
+
+/*
+ * standard pacers, registered under their names below, with Pacerparams of the arguments they are made with.
+ * pacers are asked about bit b of the interleaved key knowing only which dimensions have stopped, and at what length:
+ * these replay the interleaving up to b as if the dimensions not stopped yet were endless, so a dimension that is
+ * used up drops out, and the others go on paced the same way among themselves.
+ */
+func init() {
+	dims := func(pace func(uint) Dimpace) Pacermaker {
+		return func(params []byte) (Dimpace, error) {
+			v, err := pacerargs(params)
+			if err != nil || len(v) != 1 || v[0] == 0 {
+				return nil, ErrInvalid
+			}
+			return pace(v[0]), nil
+		}
+	}
+	RegisterPacer("roundrobin", dims(Roundrobin))
+	RegisterPacer("morton", dims(Morton))
+	RegisterPacer("dimmajor", dims(Dimmajor))
+	RegisterPacer("hilbert", dims(Morton)) // on keys gone through Hilbertkey; see Hilbertstore
+	RegisterPacer("chunked", func(params []byte) (Dimpace, error) {
+		v, err := pacerargs(params)
+		if err != nil || len(v) != 2 || v[0] == 0 || v[1] == 0 {
+			return nil, ErrInvalid
+		}
+		return Chunked(v[0], v[1]), nil
+	})
+	RegisterPacer("weighted", func(params []byte) (Dimpace, error) {
+		v, err := pacerargs(params)
+		if err != nil || len(v) == 0 {
+			return nil, ErrInvalid
+		}
+		return Weighted(v...), nil
+	})
+}
+
+/*
+ * a bit of each of dims dimensions in turn
+ */
+func Roundrobin(dims uint) Dimpace {
+	return Chunked(dims, 1)
+}
+
+/*
+ * Z-order: bits interleaved one at a time, as Roundrobin does; for fixed width coordinates, keys sort by the Z curve.
+ * the Hilbert curve is paced the same way, once coordinates have gone through Hilbertkey: a store created with
+ * the "hilbert" pacer records that its keys have, and such a store is only to be had as a Hilbertstore, which
+ * does so on the way in and out.
+ */
+func Morton(dims uint) Dimpace {
+	return Roundrobin(dims)
+}
+
+/*
+ * n bits of each of dims dimensions in turn
+ */
+func Chunked(dims, n uint) Dimpace {
+	w := make([]uint, max(dims, 1))
+
+	for d := range w {
+		w[d] = n
+	}
+	return Weighted(w...)
+}
+
+/*
+ * weights[d] bits of each dimension d in turn; a dimension weighing 0 gets none, so its keys have to be empty.
+ */
+func Weighted(weights ...uint) Dimpace {
+	w := slices.Clone(weights)
+
+	return func(b uint, stopmap map[uint]uint) (uint, uint) {
+		return cycle(w, b, stopmap)
+	}
+}
+
+/*
+ * dimensions one after the other: all bits of dimension 0, then of 1, and so on.
+ */
+func Dimmajor(dims uint) Dimpace {
+	return func(b uint, stopmap map[uint]uint) (uint, uint) {
+		for d := uint(0); d < dims; d++ {
+			if _, stopped := stopmap[d]; !stopped {
+				return d, 0
+			}
+		}
+		return 0, 0 // all stopped: the key ends
+	}
+}
+
+/*
+ * the dimension of bit b of dimensions taking w[d] bits each in turn.
+ * whole cycles are skipped while no dimension that stopped can reach its length in them.
+ */
+func cycle(w []uint, b uint, stopmap map[uint]uint) (uint, uint) {
+	used := make([]uint, len(w)) // bits each dimension had
+	gone := make([]bool, len(w))
+	live := len(w)
+
+	for d := range w {
+		if w[d] == 0 {
+			gone[d], live = true, live-1
+		}
+	}
+	if live == 0 {
+		return 0, 0
+	}
+
+	for d, k := 0, uint(0); ; {
+		if d == 0 && k == 0 {
+			bits, skip := uint(0), ^uint(0)
+			for e := range w {
+				if gone[e] {
+					continue
+				}
+				bits += w[e]
+				if l, stopped := stopmap[uint(e)]; stopped {
+					skip = min(skip, (l-used[e])/w[e])
+				}
+			}
+			skip = min(skip, b/bits)
+			for e := range w {
+				if !gone[e] {
+					used[e] += skip * w[e]
+				}
+			}
+			b -= skip * bits
+		}
+
+		l, stopped := stopmap[uint(d)]
+		switch {
+		case gone[d] || k == w[d]:
+			d, k = (d+1)%len(w), 0
+		case stopped && used[d] == l:
+			if gone[d], live = true, live-1; live == 0 {
+				return 0, 0 // all stopped: the key ends
+			}
+			d, k = (d+1)%len(w), 0
+		case b == 0:
+			return uint(d), 0
+		default:
+			b, used[d], k = b-1, used[d]+1, k+1
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package keystore
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+/*
+ * the dimensions pace hands out bits 0.. to, with stopmap as it stands
+ */
+func paced(pace Dimpace, n uint, stopmap map[uint]uint) []uint {
+	ds := []uint{}
+
+	for b := uint(0); b < n; b++ {
+		d, _ := pace(b, stopmap)
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+/*
+ * the standard pacers interleave as they say, and a dimension that stopped drops out
+ */
+func TestStdpace(t *testing.T) {
+	cases := []struct {
+		name    string
+		pace    Dimpace
+		stopmap map[uint]uint
+		want    []uint
+	}{
+		{"roundrobin", Roundrobin(2), nil, []uint{0, 1, 0, 1, 0, 1}},
+		{"roundrobin, 0 stopped at 1", Roundrobin(2), map[uint]uint{0: 1}, []uint{0, 1, 1, 1, 1, 1}},
+		{"roundrobin of 3, 1 stopped at 0", Roundrobin(3), map[uint]uint{1: 0}, []uint{0, 2, 0, 2, 0, 2}},
+		{"morton", Morton(2), nil, []uint{0, 1, 0, 1, 0, 1}},
+		{"chunked", Chunked(2, 2), nil, []uint{0, 0, 1, 1, 0, 0, 1, 1}},
+		{"chunked, 1 stopped at 3", Chunked(2, 2), map[uint]uint{1: 3}, []uint{0, 0, 1, 1, 0, 0, 1, 0, 0, 0}},
+		{"weighted", Weighted(1, 3), nil, []uint{0, 1, 1, 1, 0, 1, 1, 1}},
+		{"weighted, 0 weighing nothing", Weighted(0, 1), nil, []uint{1, 1, 1}},
+		{"dimmajor", Dimmajor(2), nil, []uint{0, 0, 0, 0}},
+		{"dimmajor, 0 stopped at 2", Dimmajor(2), map[uint]uint{0: 2}, []uint{1, 1, 1, 1}},
+	}
+	for _, c := range cases {
+		if c.stopmap == nil {
+			c.stopmap = map[uint]uint{}
+		}
+		if got := paced(c.pace, uint(len(c.want)), c.stopmap); !slices.Equal(got, c.want) {
+			t.Errorf("%v: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+/*
+ * keys of any lengths, stored by each of the standard pacers, come back as they went in
+ */
+func TestStdpaceStore(t *testing.T) {
+	pacers := map[string]Dimpace{
+		"roundrobin": Roundrobin(2),
+		"chunked":    Chunked(2, 3),
+		"weighted":   Weighted(2, 1),
+		"dimmajor":   Dimmajor(2),
+	}
+	for name, pace := range pacers {
+		k, _ := newstore(t, 64, Config{Dims: 2, Dimpace: pace})
+		want := []string{}
+		for i := 0; i < 30; i++ {
+			key := []string{fmt.Sprintf("%x", i*i), fmt.Sprintf("%d", i%7)[:i%2]}
+			if _, err := k.Insert(skeys(key...)); err != nil {
+				t.Fatalf("%v: Insert(%q): %v", name, key, err)
+			}
+			want = append(want, key[0]+"/"+key[1])
+		}
+		got := retrieveall(t, k, 2)
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("%v: stored %q, want %q", name, got, want)
+		}
+	}
+}
@@ -32,6 +32,7 @@ type segment struct {
 	has_stop   bool
 	stralign   uint
 	strings    []str
+	syms       []uint8 // strings flattened, one symbol per bit or stop; this is what gets searched and modified
 	f          fork
 	r          remote
 }
@@ -0,0 +1,228 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"bucket"
+)
+
+/*
+ * a store made by Create describes itself in a superblock, the first block of its bucket, for Open to go by.
+ * little endian, at the start of the block:
+ *   magic[4] version[2] codec[1] flags[1] bufsize[4] dims[2] pacerlen[2] root[8] rootgen[8] paramslen[2]
+ *   pacer[pacerlen] params[paramslen]
+ * the root block is rewritten in place, so the superblock is written once. rootgen, the gen the root was
+ * kept with, tells Open whether the root block is still the one Create made, if the bucket is a Genner.
+ */
+const Superblock = bucket.Block(0)
+
+const (
+	sbmagic   = "kst3"
+	sbversion = 2
+	sbhdrsize = 34
+)
+
+const (
+	codecnone = iota
+	codecgzip
+)
+
+const sbvalues = 1 // flag: keys have values
+
+var (
+	ErrNoSuperblock = errors.New("no superblock")
+	ErrVersion      = errors.New("unsupported superblock version")
+	ErrMismatch     = errors.New("store does not match")
+	ErrNotEmpty     = errors.New("bucket not empty")
+)
+
+type superblock struct {
+	version uint16
+	codec   uint8
+	flags   uint8
+	bufsize uint32
+	dims    uint16
+	root    bucket.Block
+	rootgen bucket.Gen
+	pacer   string
+	params  []byte
+}
+
+func (sb *superblock) marshall(buf []byte) error {
+	if sbhdrsize+len(sb.pacer)+len(sb.params) > len(buf) || len(sb.pacer) > 0xffff || len(sb.params) > 0xffff {
+		return ErrBufsize
+	}
+	le := binary.LittleEndian
+	copy(buf, sbmagic)
+	le.PutUint16(buf[4:], sb.version)
+	buf[6], buf[7] = sb.codec, sb.flags
+	le.PutUint32(buf[8:], sb.bufsize)
+	le.PutUint16(buf[12:], sb.dims)
+	le.PutUint16(buf[14:], uint16(len(sb.pacer)))
+	le.PutUint64(buf[16:], uint64(sb.root))
+	le.PutUint64(buf[24:], uint64(sb.rootgen))
+	le.PutUint16(buf[32:], uint16(len(sb.params)))
+	copy(buf[sbhdrsize:], sb.pacer)
+	copy(buf[sbhdrsize+len(sb.pacer):], sb.params)
+	return nil
+}
+
+func (sb *superblock) demarshall(buf []byte) error {
+	le := binary.LittleEndian
+
+	if len(buf) < sbhdrsize || string(buf[:4]) != sbmagic {
+		return ErrNoSuperblock
+	}
+	if sb.version = le.Uint16(buf[4:]); sb.version != sbversion {
+		return fmt.Errorf("%w: %v", ErrVersion, sb.version)
+	}
+	sb.codec, sb.flags = buf[6], buf[7]
+	sb.bufsize = le.Uint32(buf[8:])
+	sb.dims = le.Uint16(buf[12:])
+	n := int(le.Uint16(buf[14:]))
+	sb.root = bucket.Block(le.Uint64(buf[16:]))
+	sb.rootgen = bucket.Gen(le.Uint64(buf[24:]))
+	m := int(le.Uint16(buf[32:]))
+	switch {
+	case sbhdrsize+n+m > len(buf):
+		return ErrCorrupt
+	case sb.codec != codecnone && sb.codec != codecgzip:
+		return fmt.Errorf("%w: codec %v", ErrVersion, sb.codec)
+	}
+	sb.pacer = string(buf[sbhdrsize : sbhdrsize+n])
+	sb.params = slices.Clone(buf[sbhdrsize+n : sbhdrsize+n+m])
+	return nil
+}
+
+/*
+ * a new store on c.Bucket, which has to be empty: its first block becomes the superblock, recording c.
+ * c.Root is ignored, the store starts out empty.
+ * c.Pacer names the pacer in the registry, or a Dimpace outside of it that Open will have to be given again;
+ * a store paced by an unnamed Dimpace can only be checked for its dimensions when opened.
+ * one paced by "hilbert" is made by CreateHilbert instead.
+ */
+func Create(c Config) (*Keystore, error) {
+	if err := hilbertonly(c.Pacer); err != nil {
+		return nil, err
+	}
+	return create(c)
+}
+
+func create(c Config) (*Keystore, error) {
+	if c.Bucket == nil {
+		return nil, ErrNoBucket
+	}
+	buf, _, err := c.Bucket.Fetch(bucket.NOBLOCK, false)
+	if err != nil {
+		return nil, err
+	}
+	clear(*buf)
+	bn, _, err := c.Bucket.Keep(buf, false) // claim it before the root does
+	c.Bucket.Release(buf)                   // kept, it may be the block itself: not to be written to
+	if err == nil && bn != Superblock {
+		c.Bucket.Discard(bn)
+		err = ErrNotEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Root = nil
+	k, gen, err := newkeystore(c)
+	sb := superblock{version: sbversion, codec: codecnone, dims: uint16(c.Dims), pacer: c.Pacer, params: c.PacerParams}
+	if err == nil {
+		if k.Compressed {
+			sb.codec = codecgzip
+		}
+		if k.Values {
+			sb.flags |= sbvalues
+		}
+		sb.bufsize, sb.root, sb.rootgen = uint32(k.Bufsize), k.Root, gen
+		if buf, _, err = c.Bucket.Fetch(bucket.NOBLOCK, false); err == nil {
+			clear(*buf)
+			if err = sb.marshall(*buf); err == nil {
+				err = c.Bucket.Replace(Superblock, buf, 0, bucket.NOLINK, false)
+			}
+			c.Bucket.Release(buf)
+		}
+		if err != nil {
+			c.Bucket.Discard(k.Root)
+		}
+	}
+	if err != nil {
+		c.Bucket.Discard(Superblock)
+		return nil, err
+	}
+	return k, nil
+}
+
+/*
+ * the store Create made on c.Bucket, as its superblock describes it.
+ * with neither c.Dimpace nor c.Pacer, keys are paced by the pacer recorded, as found in the registry;
+ * otherwise c.Pacer and c.PacerParams have to be what was recorded, and c.Dimpace, if given, is trusted to match them.
+ * Bufsize, Compressed, Dims and Values, if set in c, have to agree with the superblock; c.Root is ignored.
+ * a root block kept anew since Create fails with ErrMismatch, where the bucket can tell, and so does a store
+ * paced by "hilbert", which is opened by OpenHilbert.
+ */
+func Open(c Config) (*Keystore, error) {
+	k, err := open(c)
+	if err == nil {
+		err = hilbertonly(k.Pacer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func open(c Config) (*Keystore, error) {
+	if c.Bucket == nil {
+		return nil, ErrNoBucket
+	}
+	buf, _, err := c.Bucket.Fetch(Superblock, false)
+	if err != nil {
+		return nil, err
+	}
+	sb := superblock{}
+	err = sb.demarshall(*buf)
+	if c.Bucket.Release(buf); err != nil {
+		return nil, err
+	}
+
+	if c.Dimpace == nil && c.Pacer == "" {
+		c.Pacer, c.PacerParams = sb.pacer, sb.params
+	}
+	switch {
+	case c.Bufsize != 0 && c.Bufsize != int(sb.bufsize):
+		return nil, fmt.Errorf("%w: bufsize %v, not %v", ErrMismatch, sb.bufsize, c.Bufsize)
+	case c.Compressed && sb.codec != codecgzip:
+		return nil, fmt.Errorf("%w: not compressed", ErrMismatch)
+	case c.Values && sb.flags&sbvalues == 0:
+		return nil, fmt.Errorf("%w: keeps no values", ErrMismatch)
+	case c.Dims != 0 && c.Dims != int(sb.dims):
+		return nil, fmt.Errorf("%w: %v dimensions, not %v", ErrMismatch, sb.dims, c.Dims)
+	case c.Pacer != sb.pacer:
+		return nil, fmt.Errorf("%w: paced by %q, not %q", ErrMismatch, sb.pacer, c.Pacer)
+	case !bytes.Equal(c.PacerParams, sb.params):
+		return nil, fmt.Errorf("%w: pacer params %x, not %x", ErrMismatch, sb.params, c.PacerParams)
+	}
+	c.Bufsize, c.Root, c.Compressed, c.Dims = int(sb.bufsize), &sb.root, sb.codec == codecgzip, int(sb.dims)
+	c.Values = sb.flags&sbvalues != 0
+	k, _, err := newkeystore(c)
+	if err != nil {
+		return nil, err
+	}
+	if g, ok := c.Bucket.(bucket.Genner); ok {
+		switch gen, err := g.Gen(sb.root); {
+		case errors.Is(err, bucket.ErrNoGen):
+		case err != nil:
+			return nil, err
+		case gen != sb.rootgen:
+			return nil, fmt.Errorf("%w: root block of gen %v, not %v", ErrMismatch, gen, sb.rootgen)
+		}
+	}
+	return k, nil
+}
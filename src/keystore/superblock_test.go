@@ -0,0 +1,164 @@
+package keystore
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"bucket"
+	"bucket_file"
+	"bucket_mem"
+)
+
+/*
+ * a store made by Create on a file is there again once the file is opened anew
+ */
+func TestCreateOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	bkt, err := bucket_file.Open(path, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := Create(Config{Bucket: bkt, Dims: 2, Dimpace: alternate, Values: true})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err = k.Put(skeys("a", "b"), []byte("ab")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err = bkt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if bkt, err = bucket_file.Open(path, 128); err != nil {
+		t.Fatal(err)
+	}
+	defer bkt.Close()
+	if _, err = Open(Config{Bucket: bkt}); err != ErrDims {
+		t.Errorf("Open of a store of 2 dimensions without a Dimpace = %v, want ErrDims", err)
+	}
+	again, err := Open(Config{Bucket: bkt, Dimpace: alternate})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if again.Root != k.Root || again.Bufsize != 128 || again.Dims != 2 || !again.Values {
+		t.Errorf("opened root %v, bufsize %v, dims %v, values %v", again.Root, again.Bufsize, again.Dims, again.Values)
+	}
+	if v, err := again.Get(skeys("a", "b")); err != nil || string(v) != "ab" {
+		t.Errorf("Get = %q, %v", v, err)
+	}
+}
+
+/*
+ * Open with a configuration the superblock does not agree with, or of a superblock it cannot read
+ */
+func TestOpenErrors(t *testing.T) {
+	bkt := bucket_mem.New(64)
+	if _, err := Create(Config{Bucket: bkt, Dims: 1}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	mismatches := []Config{
+		{Bufsize: 128},
+		{Compressed: true},
+		{Values: true},
+		{Dims: 2, Dimpace: alternate},
+	}
+	for _, c := range mismatches {
+		c.Bucket = bkt
+		if _, err := Open(c); !errors.Is(err, ErrMismatch) {
+			t.Errorf("Open with %+v = %v, want ErrMismatch", c, err)
+		}
+	}
+	if _, err := Open(Config{Bucket: bkt, Dims: 1}); err != nil {
+		t.Errorf("Open: %v", err)
+	}
+
+	patch := func(off int, b []byte) {
+		t.Helper()
+		buf := bucket.Buf(b)
+		if err := bkt.Replace(Superblock, &buf, uint(off), bucket.NOLINK, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patch(24, binary.LittleEndian.AppendUint64(nil, 77)) // rootgen
+	if _, err := Open(Config{Bucket: bkt}); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Open of a store whose root was kept anew = %v, want ErrMismatch", err)
+	}
+	patch(4, []byte{9, 0})
+	if _, err := Open(Config{Bucket: bkt}); !errors.Is(err, ErrVersion) {
+		t.Errorf("Open of version 9 = %v, want ErrVersion", err)
+	}
+	patch(0, []byte("xxxx"))
+	if _, err := Open(Config{Bucket: bkt}); err != ErrNoSuperblock {
+		t.Errorf("Open without the magic = %v, want ErrNoSuperblock", err)
+	}
+	if _, err := Open(Config{}); err != ErrNoBucket {
+		t.Errorf("Open without a bucket = %v, want ErrNoBucket", err)
+	}
+}
+
+/*
+ * Create takes the first block of an empty bucket, and fails on any other, leaving it as it was
+ */
+func TestCreateNotEmpty(t *testing.T) {
+	bkt := newcounting(64)
+	if _, err := New(Config{Bucket: bkt}); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	before := bkt.blocks()
+	if _, err := Create(Config{Bucket: bkt}); err != ErrNotEmpty {
+		t.Errorf("Create on a bucket in use = %v, want ErrNotEmpty", err)
+	}
+	if n := bkt.blocks(); n != before {
+		t.Errorf("%v blocks after a failed Create, %v before", n, before)
+	}
+
+	k, err := Create(Config{Bucket: newcounting(64)})
+	switch {
+	case err != nil:
+		t.Fatalf("Create: %v", err)
+	case k.Root == Superblock:
+		t.Errorf("root is the superblock")
+	}
+	insert(t, k, "a")
+	if got := retrieveall(t, k, 1); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("stored %q", got)
+	}
+}
+
+/*
+ * a bucket telling of buffers written to after they were kept, which on a copyless bucket writes the block itself
+ */
+type keptonly struct {
+	bucket.Bucket
+	kept    map[*bucket.Buf]bool
+	written bool
+}
+
+func (k *keptonly) Keep(b *bucket.Buf, decref bool) (bucket.Block, bucket.Gen, error) {
+	k.kept[b] = true
+	return k.Bucket.Keep(b, decref)
+}
+
+func (k *keptonly) Replace(d bucket.Block, b *bucket.Buf, off uint, l bucket.Link, decref bool) error {
+	k.written = k.written || k.kept[b]
+	return k.Bucket.Replace(d, b, off, l, decref)
+}
+
+/*
+ * Create writes the superblock from a buffer of its own, not from the one it claimed the block with
+ */
+func TestCreateKept(t *testing.T) {
+	bkt := &keptonly{Bucket: bucket_mem.New(64), kept: map[*bucket.Buf]bool{}}
+
+	if _, err := Create(Config{Bucket: bkt}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if bkt.written {
+		t.Errorf("Create wrote through a buffer it had kept")
+	}
+	if _, err := Open(Config{Bucket: bkt}); err != nil {
+		t.Errorf("Open: %v", err)
+	}
+}
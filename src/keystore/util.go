@@ -25,28 +25,56 @@ func forkfan(bs int, compressed bool) (uint, uint, uint) { // return max fork fa
 	}
 }
 
-func (k Key) Substr(from, length uint) Key {
-	if (from + length) > k.Bitlen {
-		if from >= k.Bitlen {
-			return Key{}
-		}
-		length = k.Bitlen - from
+/*
+ * length bits of k from bit from on, as many as there are
+ */
+func (k Keyof[E]) Substr(from, length uint) Keyof[E] {
+	if from >= k.Bitlen {
+		return Keyof[E]{}
 	}
+	length = min(length, k.Bitlen-from)
 
-	shift := from & (Keyelembits - 1)
-	ret := Key{Bitlen: length, Bits: make([]Keyelem, ((length+shift)+(Keyelembits-1))/Keyelembits)}
-	ks := k.Bits[from/Keyelembits:]
+	w := elembits[E]()
+	shift := from & (w - 1)
+	ret := Keyof[E]{Bitlen: length, Bits: make([]E, (length+(w-1))/w)}
+	ks := k.Bits[from/w:]
 
 	for i := range ret.Bits {
 		ret.Bits[i] = (ks[i] << shift)
-		if shift > 0 {
-			ret.Bits[i] |= ks[i+1] >> (Keyelembits - shift)
+		if shift > 0 && i+1 < len(ks) {
+			ret.Bits[i] |= ks[i+1] >> (w - shift)
 		}
 	}
+	ret.trim()
 
 	return ret
 }
 
+/*
+ * # of bits in an E
+ */
+func elembits[E Keyelems]() uint {
+	return uint(bits.Len64(uint64(^E(0))))
+}
+
+func (k Keyof[E]) bit(i uint) uint8 {
+	w := elembits[E]()
+	return uint8(k.Bits[i/w]>>(w-1-i%w)) & 1
+}
+
+func (k *Keyof[E]) append(bit uint8) {
+	w := elembits[E]()
+	if k.Bitlen == uint(len(k.Bits))*w {
+		k.Bits = append(k.Bits, 0)
+	}
+	k.Bits[k.Bitlen/w] |= E(bit) << (w - 1 - k.Bitlen%w)
+	k.Bitlen++
+}
+
+func (k Keyof[E]) valid() bool {
+	return k.Bitlen <= uint(len(k.Bits))*elembits[E]()
+}
+
 /*
  * Given a minimum shorthand length in each dimension, return the minimum total shorthand length.
  * for meaningful results, zero members should be omitted from the shorthands map.
@@ -70,15 +98,17 @@ func Shorthandlen(dimpace func(uint) uint, shorthands map[uint]uint) uint {
 
 /*
  * Takes no args, rather normalizes and checks values preassigned to k.
- * Should be called once, before any insert/delete/replace/retrieve ops are attempted.
- * @@@ is this really needed??? @@@
+ * Should be called once, before any insert/delete/replace/retrieve ops are attempted; New does.
  */
-func (k Keystore) Init() {
-	if k.Bucket == nil {
-		panic("uninitialized bucket")
+func (k *Keystore) Init() error {
+	switch {
+	case k.Bucket == nil:
+		return ErrNoBucket
+	case k.Root == bucket.NOBLOCK:
+		return ErrNoRoot
 	}
-	if k.Root == bucket.NOBLOCK {
-		panic("unknown root")
+	if k.forkfanout, k.forkwidth, _ = forkfan(k.Bufsize, k.Compressed); k.forkwidth == 0 {
+		return ErrBufsize
 	}
-	k.forkfanout, k.forkwidth, _ = forkfan(k.Bufsize, k.Compressed)
+	return nil
 }
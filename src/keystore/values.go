@@ -0,0 +1,393 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"slices"
+	"bucket"
+)
+
+/*
+ * a store made with Values keeps a value with each key, in a dimension of its own past the last one of the key:
+ * the pacer takes it up once all the others have stopped, so its symbols follow the key's stops in the key's
+ * leaf segment, and no two values can be stored under the same key.
+ * a value is kept in that dimension as a flag bit followed by
+ *   0: the value itself, for values of up to Bufsize/8 bytes
+ *   1: block[64] length[64] crc32[32] of a chain of overflow blocks, each next[8] (little endian) then data;
+ *      the last one's next is NOBLOCK.
+ * keys are handed out without their value dimension, and take it without having it;
+ * shorthands are not to be had.
+ * overflow blocks belong to the key pointing at them: whoever takes the key out discards them. a reader
+ * may thus find them gone, or holding something else, which the crc tells: the key's value has been replaced.
+ */
+var ErrNoValues = errors.New("store does not keep values")
+
+const (
+	valinline   = 0
+	valoverflow = 1
+	valrefbits  = 1 + 64 + 64 + 32
+	ovhdrsize   = 8
+)
+
+/*
+ * all dimensions but the value one have stopped
+ */
+func valued(p *pacer) bool {
+	return len(p.stopmap) == len(p.key)-1
+}
+
+/*
+ * key with value dimension v
+ */
+func withvalue(key []Key, v Key) []Key {
+	return append(slices.Clone(key), v)
+}
+
+/*
+ * two stored keys that are the same but for their values
+ */
+func sameholder(a, b []Key) bool {
+	return slices.EqualFunc(a[:len(a)-1], b[:len(b)-1], Key.Equal)
+}
+
+func exactall(n int) []bool {
+	exact := make([]bool, n)
+
+	for d := range exact {
+		exact[d] = true
+	}
+	return exact
+}
+
+/*
+ * the value dimension for v, writing its overflow blocks if it needs any
+ */
+func (k Keystore) keepvalue(v []byte) (Key, error) {
+	if len(v) <= k.Bufsize/8 {
+		return appendbytes(appendbits(Key{}, valinline, 1), v, false), nil
+	}
+	next, room := bucket.NOBLOCK, k.Bufsize-ovhdrsize
+	chain := []bucket.Block{}
+
+	for off := (len(v) - 1) / room * room; off >= 0; off -= room { // back to front, each knowing its next
+		buf, _, err := k.Bucket.Fetch(bucket.NOBLOCK, false)
+		if err != nil {
+			k.Bucket.Discard(chain...)
+			return Key{}, err
+		}
+		clear(*buf)
+		binary.LittleEndian.PutUint64(*buf, uint64(next))
+		copy((*buf)[ovhdrsize:], v[off:])
+		if next, _, err = k.Bucket.Keep(buf, true); err != nil {
+			k.Bucket.Discard(chain...)
+			return Key{}, err
+		}
+		chain = append(chain, next)
+	}
+	vk := appendbits(appendbits(Key{}, valoverflow, 1), uint64(next), 64)
+	return appendbits(appendbits(vk, uint64(len(v)), 64), uint64(crc32.ChecksumIEEE(v)), 32), nil
+}
+
+/*
+ * the value value dimension vk holds, and the overflow blocks it is read from.
+ * ErrNotFound if these are not what vk points at any more.
+ */
+func (k Keystore) readvalue(vk Key) ([]byte, []bucket.Block, error) {
+	switch {
+	case !vk.valid() || vk.Bitlen == 0:
+		return nil, nil, ErrCorrupt
+	case vk.bit(0) == valinline:
+		v, _, err := decodebytes(vk, 1, false)
+		if err != nil {
+			return nil, nil, ErrCorrupt
+		}
+		return v, nil, nil
+	case vk.Bitlen != valrefbits:
+		return nil, nil, ErrCorrupt
+	}
+	bn, n, sum := bucket.Block(vk.bits(1, 64)), vk.bits(65, 64), uint32(vk.bits(129, 32))
+	v, chain := []byte{}, []bucket.Block{}
+
+	for uint64(len(v)) < n {
+		if bn == bucket.NOBLOCK {
+			return nil, nil, ErrNotFound
+		}
+		buf, _, err := k.Bucket.Fetch(bn, false)
+		switch {
+		case errors.Is(err, bucket.ErrNoBlock):
+			return nil, nil, ErrNotFound
+		case err != nil:
+			return nil, nil, err
+		}
+		chain = append(chain, bn)
+		bn = bucket.Block(binary.LittleEndian.Uint64(*buf))
+		v = append(v, (*buf)[ovhdrsize:ovhdrsize+min(uint64(len(*buf)-ovhdrsize), n-uint64(len(v)))]...)
+		k.Bucket.Release(buf)
+	}
+	if crc32.ChecksumIEEE(v) != sum {
+		return nil, nil, ErrNotFound
+	}
+	return v, chain, nil
+}
+
+/*
+ * discard the overflow blocks of a value dimension no key points at any more
+ */
+func (k Keystore) dropvalue(vk Key) error {
+	if !vk.valid() || vk.Bitlen != valrefbits || vk.bit(0) != valoverflow {
+		return nil
+	}
+	_, chain, err := k.readvalue(vk)
+	if err != nil {
+		return err
+	}
+	return k.Bucket.Discard(chain...)
+}
+
+/*
+ * Insert with a value: a key stored already keeps the value it has, and Insert says so as usual.
+ */
+func (k Keystore) insertvalue(key []Key, o InsertOptions) ([]int, error) {
+	switch {
+	case !k.Values:
+		return nil, ErrNoValues
+	case o.Shorthands > 0:
+		return nil, ErrInvalid
+	}
+	vk, err := k.keepvalue(o.Value)
+	if err != nil {
+		return nil, err
+	}
+	uniq, err := k.insertkey(withvalue(key, vk), 0)
+	if err != nil || uniq[0] > int(key[0].Bitlen) {
+		k.dropvalue(vk)
+	}
+	switch {
+	case err != nil:
+		return nil, err
+	case o.IfAbsent && uniq[0] > int(key[0].Bitlen):
+		return nil, ErrExists
+	}
+	return uniq[:len(key)], nil
+}
+
+/*
+ * store value with key, in place of the one it has if it is stored already.
+ */
+func (k Keystore) Put(key []Key, value []byte) error {
+	return k.PutWith(key, value, PutOptions{})
+}
+
+/*
+ * replace the value of key with value if it is expect; see conditional.go.
+ */
+func (k Keystore) CompareAndSwap(key []Key, expect, value []byte) error {
+	return k.PutWith(key, value, PutOptions{IfPresent: true, Compare: true, Expect: expect})
+}
+
+/*
+ * Put under the conditions of o: the key is inserted, or its value replaced, by a linked write of the blocks
+ * the condition was checked in. a key stored or taken out meanwhile has the lookup done again.
+ */
+func (k Keystore) PutWith(key []Key, value []byte, o PutOptions) error {
+	if err := k.checkkey(key); err != nil {
+		return err
+	}
+	switch {
+	case !k.Values:
+		return ErrNoValues
+	case o.IfAbsent && (o.IfPresent || o.Compare):
+		return ErrInvalid
+	}
+	vk, err := k.keepvalue(value)
+	if err != nil {
+		return err
+	}
+	n := len(key)
+	full := withvalue(key, vk)
+	fail := func(err error) error {
+		k.dropvalue(vk)
+		return err
+	}
+
+	for {
+		if !o.IfPresent && !o.Compare {
+			uniq, err := k.insertkey(full, 0)
+			switch {
+			case err != nil:
+				return fail(err)
+			case uniq[0] <= int(key[0].Bitlen): // was not there
+				return nil
+			case o.IfAbsent:
+				return fail(ErrExists)
+			}
+		}
+		old, err := k.stored(key, nil)
+		switch {
+		case err == ErrNotFound && (o.IfPresent || o.Compare):
+			return fail(err)
+		case err == ErrNotFound: // deleted meanwhile
+			continue
+		case err != nil:
+			return fail(err)
+		}
+		if o.Compare {
+			v, _, err := k.readvalue(old[n])
+			switch {
+			case err == ErrNotFound: // replaced meanwhile
+				continue
+			case err != nil:
+				return fail(err)
+			case !bytes.Equal(v, o.Expect):
+				return fail(ErrConflict)
+			}
+		}
+		if old[n].Equal(vk) {
+			return nil
+		}
+		switch err = k.replacekey(old, full, exactall(n+1)); {
+		case err == nil:
+			return k.dropvalue(old[n])
+		case err != ErrShared && err != ErrExists: // these: changed meanwhile
+			return fail(err)
+		}
+	}
+}
+
+/*
+ * the value stored with key; ErrNotFound if key is not stored.
+ */
+func (k Keystore) Get(key []Key) ([]byte, error) {
+	if err := k.checkkey(key); err != nil {
+		return nil, err
+	}
+	if !k.Values {
+		return nil, ErrNoValues
+	}
+	last := Key{}
+
+	for {
+		full, err := k.stored(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		vk := full[len(key)]
+		if vk.Equal(last) {
+			return nil, ErrCorrupt
+		}
+		v, _, err := k.readvalue(vk)
+		if err != ErrNotFound { // replaced under our feet: look again
+			return v, err
+		}
+		last = vk
+	}
+}
+
+/*
+ * the keys RetrieveWith returns, with their values.
+ */
+func (k Keystore) RetrieveValues(key []Key, o RetrieveOptions) ([][]Key, [][]byte, error) {
+	if !k.Values {
+		return nil, nil, ErrNoValues
+	}
+	c, err := k.CursorWith(key, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, values := [][]Key(nil), [][]byte(nil)
+
+	for c.Next() {
+		v, err := c.Value()
+		switch {
+		case err == ErrNotFound: // deleted since
+			continue
+		case err != nil:
+			return nil, nil, err
+		}
+		keys, values = append(keys, c.Key()), append(values, v)
+	}
+	if c.Err() != nil {
+		return nil, nil, c.Err()
+	}
+	return keys, values, nil
+}
+
+/*
+ * the value of the key Next advanced to; if that has been replaced since, the new one.
+ */
+func (c *Cursor) Value() ([]byte, error) {
+	k := c.w.state.k
+
+	switch {
+	case !k.Values:
+		return nil, ErrNoValues
+	case c.key == nil:
+		return nil, ErrInvalid
+	}
+	v, _, err := k.readvalue(c.key[len(c.key)-1])
+	if err == ErrNotFound {
+		return k.Get(c.Key())
+	}
+	return v, err
+}
+
+/*
+ * Delete, one stored key at a time, discarding the overflow blocks of the keys it took out.
+ */
+func (k Keystore) deletevalues(key []Key, exact []bool) error {
+	matchlen := map[int]int{}
+
+	for d := range key {
+		if exact[d] {
+			matchlen[d] = int(key[d].Bitlen) + 1
+		}
+	}
+	c, err := k.CursorWith(key, RetrieveOptions{Matchlen: matchlen})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for c.Next() {
+		full := c.key
+		gone, err := k.deletekey(full, exactall(len(full)))
+		if err != nil {
+			return err
+		}
+		if gone {
+			k.dropvalue(full[len(key)])
+		}
+	}
+	return c.Err()
+}
+
+/*
+ * Replace, the new key keeping the value of the old one.
+ */
+func (k Keystore) replacevalue(oldkey, newkey []Key, exact []bool) error {
+	matchlen := map[int]int{}
+
+	for d := range oldkey {
+		if exact[d] {
+			matchlen[d] = int(oldkey[d].Bitlen) + 1
+		}
+	}
+	c, err := k.CursorWith(oldkey, RetrieveOptions{Matchlen: matchlen, Maxkeys: 2})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	found := [][]Key{}
+	for c.Next() {
+		found = append(found, c.key)
+	}
+	switch {
+	case c.Err() != nil:
+		return c.Err()
+	case len(found) != 1:
+		return ErrShared
+	}
+	old := found[0]
+	return k.replacekey(old, withvalue(newkey, old[len(oldkey)]), exactall(len(old)))
+}
@@ -0,0 +1,142 @@
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+/*
+ * a value of n bytes, different for each seed
+ */
+func value(n int, seed byte) []byte {
+	v := make([]byte, n)
+
+	for i := range v {
+		v[i] = seed + byte(i*7)
+	}
+	return v
+}
+
+/*
+ * small values are kept inline, large ones in overflow blocks; either comes back from Get and RetrieveValues
+ */
+func TestValues(t *testing.T) {
+	k, _ := newstore(t, 128, Config{Values: true})
+	want := map[string][]byte{"a": {}, "ab": value(16, 1), "b": value(17, 2), "c": value(1000, 3)}
+
+	for s, v := range want {
+		if err := k.Put(skeys(s), v); err != nil {
+			t.Fatalf("Put(%q): %v", s, err)
+		}
+	}
+	for s, v := range want {
+		if got, err := k.Get(skeys(s)); err != nil || !bytes.Equal(got, v) {
+			t.Errorf("Get(%q) = %v bytes, %v; want %v", s, len(got), err, len(v))
+		}
+	}
+	if _, err := k.Get(skeys("d")); err != ErrNotFound {
+		t.Errorf("Get of a key not stored = %v, want ErrNotFound", err)
+	}
+	keys, values, err := k.RetrieveValues(skeys(""), RetrieveOptions{Matchlen: map[int]int{0: 0}})
+	if err != nil || !slices.Equal(kstrings(keys), []string{"a", "ab", "b", "c"}) {
+		t.Fatalf("RetrieveValues = %q, %v", kstrings(keys), err)
+	}
+	for i, key := range keys {
+		if s := string(key[0].Bytes()); !bytes.Equal(values[i], want[s]) {
+			t.Errorf("RetrieveValues: %q with %v bytes, want %v", s, len(values[i]), len(want[s]))
+		}
+	}
+	if got := retrieveall(t, k, 1); !slices.Equal(got, []string{"a", "ab", "b", "c"}) {
+		t.Errorf("Retrieve = %q, keys with their values dimension", got)
+	}
+}
+
+/*
+ * an Insert of a key stored already keeps the value it has, and leaves no overflow blocks behind
+ */
+func TestValuesInsert(t *testing.T) {
+	k, bkt := newstore(t, 128, Config{Values: true})
+
+	if _, err := k.InsertWith(skeys("a"), InsertOptions{Value: value(300, 1)}); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	n := bkt.blocks()
+	if uniq, err := k.InsertWith(skeys("a"), InsertOptions{Value: value(300, 2)}); err != nil || uniq[0] != 9 {
+		t.Errorf("Insert of a stored key = %v, %v; want its stop", uniq, err)
+	}
+	if _, err := k.InsertWith(skeys("a"), InsertOptions{Value: value(300, 2), IfAbsent: true}); err != ErrExists {
+		t.Errorf("Insert IfAbsent of a stored key = %v, want ErrExists", err)
+	}
+	if v, err := k.Get(skeys("a")); err != nil || !bytes.Equal(v, value(300, 1)) {
+		t.Errorf("Get after inserting again = %v, %v; want the first value", len(v), err)
+	}
+	if bkt.blocks() != n {
+		t.Errorf("inserting a stored key again left %v blocks, not %v", bkt.blocks(), n)
+	}
+	if _, err := k.InsertWith(skeys("b"), InsertOptions{Value: []byte{1}, Shorthands: 4}); err != ErrInvalid {
+		t.Errorf("Insert with a value and shorthands = %v, want ErrInvalid", err)
+	}
+}
+
+/*
+ * overflow blocks go once no key points at them: when Put replaces the value, and when Delete takes the key out
+ */
+func TestValuesOverflow(t *testing.T) {
+	k, bkt := newstore(t, 128, Config{Values: true})
+	empty := bkt.blocks()
+
+	if err := k.Put(skeys("k"), []byte("small")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	small := bkt.blocks()
+	for i := byte(0); i < 3; i++ {
+		if err := k.Put(skeys("k"), value(500, i)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if n := bkt.blocks(); n != small+5 {
+			t.Errorf("value of 500 bytes (%vth) in %v blocks, want 5 of 120 bytes", i, n-small)
+		}
+	}
+	if err := k.Put(skeys("k"), []byte("small")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if n := bkt.blocks(); n != small {
+		t.Errorf("Put of a small value left %v blocks, want %v", n, small)
+	}
+	k.Put(skeys("k"), value(500, 9))
+	k.Put(skeys("kk"), value(500, 8))
+	if err := k.Delete(skeys("k"), []bool{true}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if v, err := k.Get(skeys("kk")); err != nil || !bytes.Equal(v, value(500, 8)) {
+		t.Errorf("Get of the key left = %v bytes, %v", len(v), err)
+	}
+	if err := k.Delete(skeys("k")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if n := bkt.blocks(); n != empty {
+		t.Errorf("deleting all keys left %v blocks, want %v", n, empty)
+	}
+}
+
+/*
+ * a store not keeping values says so
+ */
+func TestNoValues(t *testing.T) {
+	k, _ := newstore(t, 128, Config{})
+
+	if err := k.Put(skeys("a"), nil); err != ErrNoValues {
+		t.Errorf("Put = %v, want ErrNoValues", err)
+	}
+	if _, err := k.Get(skeys("a")); err != ErrNoValues {
+		t.Errorf("Get = %v, want ErrNoValues", err)
+	}
+	if _, _, err := k.RetrieveValues(skeys("a"), RetrieveOptions{}); !errors.Is(err, ErrNoValues) {
+		t.Errorf("RetrieveValues = %v, want ErrNoValues", err)
+	}
+	if _, err := k.InsertWith(skeys("a"), InsertOptions{Value: []byte{1}}); err != ErrNoValues {
+		t.Errorf("Insert with a value = %v, want ErrNoValues", err)
+	}
+}
@@ -0,0 +1,225 @@
+package keystore
+
+import (
+	"slices"
+	"bucket"
+)
+
+/*
+ * range walk for Retrieve and Cursor:
+ * depth first through the stored keys, taking the branches of each fork in the order of their symbols
+ * (reversed if so asked for the fork's dimension), decoding keys on the way.
+ * a branch is pruned as soon as it does not match key in the first matchlen symbols of some dimension,
+ * or falls on the wrong side of key in some dimension.
+ * a shorthand walk only takes the branches a shorthand lookup would (see shorthand.go).
+ * the walk is kept as a stack of segments yet to be walked, so that it can stop at each key; it is (re)started
+ * past a key by seeking: following that key down, and only taking the branches that come after it.
+ */
+type walker struct {
+	state     searchstate
+	key       []Key
+	matchlen  []uint // per dimension # of symbols that must match key; Bitlen+1 to include the stop
+	reverse   []bool
+	maxkeys   int // 0 for all
+	shorthand bool
+	after     []Key  // key handed out last; the walk goes on past it
+	todo      []task // next one last
+	n         int    // keys handed out
+}
+
+type task struct {
+	b    *block
+	i    int // segment
+	pos  walkpos
+	path []remcomp // down to b
+	seek bool      // the stored symbols so far are those of after
+}
+
+type walkpos struct {
+	p   *pacer // decodes the stored key into p.key
+	cmp []int  // per dimension, sign of stored key compared to key; 0 as long as they are equal
+	acc uint   // unmatched symbols, for a shorthand walk
+}
+
+func (pos walkpos) clone() walkpos {
+	return walkpos{p: pos.p.clone(), cmp: slices.Clone(pos.cmp), acc: pos.acc}
+}
+
+func (w *walker) full() bool {
+	return w.maxkeys > 0 && w.n >= w.maxkeys
+}
+
+/*
+ * the branches a shorthand walk takes where its next symbol is unmatched
+ */
+func (pos walkpos) shorthandmatch(b *block, fe []forkelem) []forkelem {
+	match := []forkelem{}
+
+	for _, e := range fe {
+		if e.shorthandmatch == pos.acc%16 {
+			match = append(match, e)
+		}
+	}
+	if len(match) == 0 && b.seg[fe[0].segidx].syms[0] == symstop {
+		match = fe[:1]
+	}
+	return match
+}
+
+/*
+ * consume a stored symbol; false if it gets the walk off range.
+ */
+func (w *walker) step(pos *walkpos, sym uint8) (bool, error) {
+	p := pos.p
+
+	if p.done() {
+		return false, ErrCorrupt
+	}
+	d, last, err := p.nextdim()
+	switch {
+	case err != nil:
+		return false, err
+	case last && sym != symstop:
+		return false, ErrCorrupt
+	}
+	switch i := uint(p.keybit[d]); {
+	case w.shorthand && i >= w.key[d].Bitlen:
+		pos.acc++
+	case w.shorthand:
+		if sym != w.key[d].bit(i) {
+			return false, nil
+		}
+	case pos.cmp[d] == 0:
+		ks := uint8(symstop)
+		if i < w.key[d].Bitlen {
+			ks = w.key[d].bit(i)
+		}
+		if sym != ks {
+			if i < w.matchlen[d] {
+				return false, nil
+			}
+			if pos.cmp[d] = int(symorder(sym)) - int(symorder(ks)); (pos.cmp[d] < 0) != w.reverse[d] {
+				return false, nil
+			}
+		}
+	}
+	if sym != symstop {
+		p.key[d].append(sym)
+	}
+	p.take(d, sym)
+	return true, nil
+}
+
+/*
+ * where stored symbol sym falls compared to the next one of after, in walk order.
+ */
+func (w *walker) order(p *pacer, sym uint8) int {
+	d, _, err := p.nextdim()
+	if err != nil {
+		return 0 // left for step to report
+	}
+	as := uint8(symstop)
+	if i := uint(p.keybit[d]); i < w.after[d].Bitlen {
+		as = w.after[d].bit(i)
+	}
+	c := int(symorder(sym)) - int(symorder(as))
+	if w.reverse[d] {
+		c = -c
+	}
+	return c
+}
+
+/*
+ * walk segment t.i of t.b: queue what hangs off it, or return the key it ends.
+ */
+func (w *walker) walk(t task) ([]Key, error) {
+	seg := &t.b.seg[t.i]
+	pos := t.pos
+
+	for _, sym := range seg.syms {
+		if t.seek {
+			switch c := w.order(pos.p, sym); {
+			case c < 0:
+				return nil, nil
+			case c > 0:
+				t.seek = false
+			}
+		}
+		if ok, err := w.step(&pos, sym); !ok || err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case seg.has_remote:
+		w.state.rempath = slices.Clip(t.path)
+		nb, err := w.state.load(seg.r)
+		if err != nil {
+			return nil, err
+		}
+		w.todo = append(w.todo, task{b: nb, pos: pos, path: w.state.rempath, seek: t.seek})
+		return nil, nil
+	case seg.has_fork:
+		d, _, err := pos.p.nextdim()
+		if err != nil {
+			return nil, err
+		}
+		fe := seg.f.fe
+		if w.shorthand && uint(pos.p.keybit[d]) >= w.key[d].Bitlen {
+			fe = pos.shorthandmatch(t.b, fe)
+		}
+		for j := range fe { // last to be walked first in
+			e := fe[len(fe)-1-j]
+			if w.reverse[d] {
+				e = fe[j]
+			}
+			w.todo = append(w.todo, task{b: t.b, i: int(e.segidx), pos: pos.clone(), path: t.path, seek: t.seek})
+		}
+		return nil, nil
+	case !pos.p.done():
+		return nil, ErrCorrupt
+	case t.seek: // after itself
+		return nil, nil
+	}
+	return pos.p.key, nil
+}
+
+/*
+ * the next key, or nil if there are no more.
+ */
+func (w *walker) next() ([]Key, error) {
+	for len(w.todo) > 0 && !w.full() {
+		t := w.todo[len(w.todo)-1]
+		w.todo = w.todo[:len(w.todo)-1]
+		key, err := w.walk(t)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			w.n++
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+/*
+ * (re)start the walk from the root, past after if set.
+ */
+func (w *walker) start() error {
+	k := w.state.k
+	n := len(w.key)
+
+	for {
+		w.todo, w.state.rempath = nil, nil
+		b, err := w.state.load(remote{bn: k.Root})
+		if _, lost := err.(bucket.Link); lost {
+			continue
+		}
+		if err != nil || len(b.seg) == 0 {
+			return err
+		}
+		pos := walkpos{p: &pacer{key: make([]Key, n), dim: k.Dimpace, keybit: make([]int, n), stopmap: make(map[uint]uint), values: k.Values}, cmp: make([]int, n)}
+		w.todo = []task{{b: b, pos: pos, path: w.state.rempath, seek: w.after != nil}}
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package keystore
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRetrieve(t *testing.T) {
+	k, _ := newstore(t, 64, Config{})
+	insert(t, k, "ba", "ab", "", "abc", "b", "a", "aba", "ac")
+
+	for _, tc := range []struct {
+		key  string
+		args []interface{}
+		want []string
+	}{
+		{"ab", nil, []string{"ab", "aba", "abc"}}, // prefix
+		{"ab", []interface{}{map[int]int{0: 0}}, []string{"ab", "aba", "abc", "ac", "b", "ba"}},
+		{"ab", []interface{}{map[int]int{0: 0}, 2}, []string{"ab", "aba"}},
+		{"ab", []interface{}{map[int]int{0: 8}}, []string{"ab", "aba", "abc", "ac"}},
+		{"ab", []interface{}{map[int]int{0: 17}}, []string{"ab"}}, // exact, stop included
+		{"abb", []interface{}{map[int]int{0: 25}}, []string{}},
+		{"ab", []interface{}{map[int]int{0: 0}, []bool{true}}, []string{"ab", "a", ""}},
+		{"ab", []interface{}{[]bool{true}}, []string{"ab"}},
+		{"b", []interface{}{[]bool{true}, map[int]int{0: 0}, 3}, []string{"b", "ac", "abc"}},
+		{"bb", []interface{}{map[int]int{0: 0}}, []string{}},
+	} {
+		keys, err := k.Retrieve(skeys(tc.key), tc.args...)
+		if got := kstrings(keys); err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("Retrieve(%q, %v) = %q, %v; want %q", tc.key, tc.args, got, err, tc.want)
+		}
+	}
+}
+
+func TestRetrieveDims(t *testing.T) {
+	k, _ := newstore(t, 64, Config{Dimpace: alternate, Dims: 2})
+	for _, key := range [][]string{{"b", "y"}, {"a", "x"}, {"b", "x"}, {"a", "y"}, {"ab", "x"}} {
+		if _, err := k.Insert(skeys(key...)); err != nil {
+			t.Fatalf("Insert(%q): %v", key, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		key  []string
+		args []interface{}
+		want []string
+	}{
+		{[]string{"", ""}, []interface{}{map[int]int{}}, []string{"a/x", "ab/x", "a/y", "b/x", "b/y"}}, // interleaved
+		{[]string{"b", ""}, []interface{}{map[int]int{1: 0}}, []string{"b/x", "b/y"}},
+		{[]string{"b", "z"}, []interface{}{map[int]int{1: 0}, []bool{false, true}}, []string{"b/y", "b/x"}},
+		{[]string{"a", "x"}, []interface{}{map[int]int{0: 8, 1: 0}}, []string{"a/x", "ab/x", "a/y"}},
+		{[]string{"a", "y"}, []interface{}{map[int]int{0: 8, 1: 0}}, []string{"a/y"}}, // not ab/x: x comes before y
+		{[]string{"", "y"}, []interface{}{map[int]int{0: 0}}, []string{"a/y", "b/y"}},
+		{[]string{"b", "y"}, []interface{}{map[int]int{0: 0, 1: 0}, []bool{true, true}, 2}, []string{"b/y", "b/x"}},
+		{[]string{"", ""}, []interface{}{map[int]int{}, []bool{true, true}}, []string{}}, // nothing sorts before the empty key
+	} {
+		keys, err := k.Retrieve(skeys(tc.key...), tc.args...)
+		if got := kstrings(keys); err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("Retrieve(%q, %v) = %q, %v; want %q", tc.key, tc.args, got, err, tc.want)
+		}
+	}
+}